@@ -1,128 +1,254 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	log "github.com/sirupsen/logrus"
 	"k8s.io/kops/pkg/apis/kops"
 )
 
-// runCheck executes the AMI availability validation flow.
-func runCheck(cfg *CheckConfig, awsSession *session.Session) error {
+// runCheck executes the AMI availability validation flow and returns its findings, including those gathered before any error.
+func runCheck(ctx context.Context, cfg *CheckConfig, clients *awsClients) (*checkReport, error) {
 	// Log start of check.
 	log.Infoln("Running check.")
+	report := newCheckReport()
 
 	// Fetch instance groups from the kops state store.
-	instanceGroups, err := listKopsInstanceGroups(cfg, awsSession)
+	instanceGroups, objectErrors, err := listKopsInstanceGroups(ctx, cfg, clients)
 	if err != nil {
-		return fmt.Errorf("failed to list kops instance groups: %w", err)
+		return report, phaseError(ctx, "state store read", fmt.Errorf("failed to list kops instance groups: %w", err))
 	}
 	log.Infoln("Retrieved kops instance groups.")
 
+	// Report unreadable instance group objects with the configured severity.
+	for _, objectErr := range objectErrors {
+		report.add(cfg.StateStoreErrorSeverity, objectErr.Error())
+	}
+
+	// Fetch the cluster spec from the kops state store.
+	cluster, clusterErr, err := loadKopsCluster(ctx, cfg, clients)
+	if err != nil {
+		return report, phaseError(ctx, "state store read", fmt.Errorf("failed to read kops cluster spec: %w", err))
+	}
+	if clusterErr != nil {
+		report.add(cfg.StateStoreErrorSeverity, clusterErr.Error())
+	}
+
+	// Fail when too few instance groups were found to trust the result.
+	err = checkInstanceGroupCount(cfg, instanceGroups)
+	if err != nil {
+		report.fail(err.Error())
+		return report, nil
+	}
+
+	// Validate the cluster spec and apply its defaults to the instance groups.
+	if cluster != nil {
+		report.fail(checkClusterSpec(cfg, cluster, instanceGroups)...)
+		applyClusterDefaults(cluster, instanceGroups)
+	}
+
+	// Resolve the image reference of each instance group.
+	groupImages, referenceErrors := resolveInstanceGroupImages(ctx, cfg, clients, instanceGroups)
+	if ctx.Err() != nil {
+		return report, phaseError(ctx, "image reference resolution", ctx.Err())
+	}
+	report.fail(referenceErrors...)
+
 	// Fetch available AMIs from EC2.
-	images, err := listEC2Images(cfg, awsSession)
+	images, err := listEC2Images(ctx, cfg, clients, groupImages)
 	if err != nil {
-		return fmt.Errorf("failed to list AMIs: %w", err)
+		return report, phaseError(ctx, "EC2 image lookup", fmt.Errorf("failed to list AMIs: %w", err))
 	}
-	log.Infof("Retrieved AWS AMIs. (Total: %d)", len(images))
+	log.Infof("Retrieved AWS AMIs for %d image lookups.", len(images))
 
-	// Check for missing AMIs and collect errors.
-	errors := checkImagesAreAvailable(instanceGroups, images)
-	if len(errors) != 0 {
-		return fmt.Errorf("%s", strings.Join(errors, "; "))
+	// Check for missing AMIs.
+	matches, missing := checkImagesAreAvailable(ctx, groupImages, images, newImageMatcher(cfg), newImageDiagnoser(clients, cfg.ImageMatchMode))
+	if ctx.Err() != nil {
+		return report, phaseError(ctx, "missing image diagnosis", ctx.Err())
 	}
+	report.fail(missing...)
 
-	log.Infoln("kops used images are available.")
-	return nil
-}
+	// Reject images from owners outside the allowlist.
+	trusted, err := loadTrustedOwners(ctx, cfg, clients)
+	if err != nil {
+		return report, phaseError(ctx, "trusted owner lookup", fmt.Errorf("failed to load trusted AMI owners: %w", err))
+	}
+	report.fail(checkImageOwners(matches, trusted)...)
 
-// checkImagesAreAvailable compares instance group images against available AMIs.
-func checkImagesAreAvailable(instanceGroups []*kops.InstanceGroup, images []*ec2.Image) []string {
-	// Prepare the error list.
-	errorMessages := make([]string, 0)
+	// Guard against images resolved from, or impersonated by, unexpected owners.
+	report.fail(checkImageOwnerMismatch(matches)...)
+	if cfg.CheckLookalikeImages {
+		lookalikes, err := checkLookalikeImages(ctx, clients, matches, trusted)
+		if err != nil {
+			return report, phaseError(ctx, "look-alike image search", err)
+		}
+		report.warn(lookalikes...)
+	}
 
-	// Iterate each instance group.
-	for _, group := range instanceGroups {
-		// Skip nil groups defensively.
-		if group == nil {
-			continue
+	// Identify the calling and cluster accounts for the account aware checks.
+	callerAccount := ""
+	clusterAccount := cfg.ClusterAccountID
+	if cfg.CheckLaunchPermissions || cfg.DeepValidation {
+		callerAccount, err = lookupCallerAccount(ctx, clients.stsClient(cfg.AWSRegion))
+		if err != nil {
+			return report, phaseError(ctx, "caller account lookup", err)
+		}
+		if len(clusterAccount) == 0 {
+			clusterAccount = callerAccount
 		}
+	}
 
-		log.Infoln("Looking at instance group:", group.Name)
+	// Confirm the cluster account may launch each image.
+	if cfg.CheckLaunchPermissions {
+		unlaunchable := checkLaunchPermissions(ctx, clients, matches, clusterAccount, callerAccount)
+		if ctx.Err() != nil {
+			return report, phaseError(ctx, "launch permission check", ctx.Err())
+		}
+		report.fail(unlaunchable...)
+	}
 
-		// Extract the image name for matching.
-		imageName, err := extractInstanceGroupImageName(group)
+	// Confirm the snapshots and KMS keys behind each image are usable.
+	if cfg.DeepValidation {
+		failures, warnings, err := checkImageSnapshots(ctx, clients, matches, clusterAccount)
 		if err != nil {
-			errorMessages = append(errorMessages, err.Error())
-			continue
+			return report, phaseError(ctx, "snapshot validation", err)
 		}
+		report.fail(failures...)
+		report.warn(warnings...)
+	}
 
-		// Check whether the AMI is present in the EC2 list.
-		found := false
-		for _, image := range images {
-			if imageMatchesInstanceGroup(image, imageName) {
-				found = true
-				break
-			}
+	// Confirm each image can boot on its group's instance types.
+	if cfg.CheckInstanceTypes {
+		incompatible, skipped, err := checkInstanceTypeCompatibility(ctx, clients, matches)
+		if err != nil {
+			return report, phaseError(ctx, "instance type check", err)
 		}
+		report.fail(incompatible...)
+		report.warn(skipped...)
+	}
 
-		// Record missing AMIs.
-		if !found {
-			message := fmt.Sprintf("could not find image matching %s", group.Spec.Image)
-			errorMessages = append(errorMessages, message)
+	// Confirm each group's root volume can hold its image.
+	report.fail(checkRootVolumes(matches)...)
+
+	// Flag images older than the maximum age.
+	stale, invalidAges := checkImageAge(matches, cfg.MaxImageAge, time.Now())
+	report.fail(invalidAges...)
+	report.add(cfg.MaxImageAgeSeverity, stale...)
+
+	// Compare images with the kops channel recommendation.
+	if len(cfg.ChannelLocation) != 0 {
+		if cluster == nil {
+			report.add(cfg.ChannelDriftSeverity, "skipped the kops channel comparison because the cluster spec could not be read")
+		} else {
+			channel, err := loadChannel(ctx, cfg.ChannelLocation)
+			if err != nil {
+				return report, phaseError(ctx, "channel load", err)
+			}
+			drift, err := checkChannelImages(channel, cluster, matches)
+			if err != nil {
+				report.fail(err.Error())
+			}
+			report.add(cfg.ChannelDriftSeverity, drift...)
 		}
 	}
 
-	return errorMessages
-}
+	// Flag deprecated and soon to be deprecated images.
+	deprecated, upcoming := checkImageDeprecation(matches, cfg.DeprecationWindow, time.Now())
+	report.fail(deprecated...)
+	report.add(cfg.DeprecationWindowSeverity, upcoming...)
 
-// extractInstanceGroupImageName trims the kops image reference to the AMI name.
-func extractInstanceGroupImageName(group *kops.InstanceGroup) (string, error) {
-	// Validate the image field.
-	if group == nil {
-		return "", fmt.Errorf("instance group was nil")
-	}
-	if len(group.Spec.Image) == 0 {
-		return "", fmt.Errorf("instance group %s does not define an image", group.Name)
+	// Summarize the run.
+	if !report.failed() {
+		log.Infoln("kops used images are available.")
 	}
+	return report, nil
+}
 
-	// The image is typically in owner/name format.
-	parts := strings.Split(group.Spec.Image, "/")
-	if len(parts) < 2 {
-		return group.Spec.Image, nil
+// unavailableImageMessage describes an image that matched but is not in the available state.
+func unavailableImageMessage(groupImage *instanceGroupImage, image *ec2.Image) string {
+	// Include the state reason when EC2 provides one.
+	message := fmt.Sprintf("[%s] instance group %s image %s resolved to %s, which is in state %s",
+		groupImage.Region, groupImage.Group.Name, groupImage.Reference.String(), aws.StringValue(image.ImageId), aws.StringValue(image.State))
+	if image.StateReason != nil && len(aws.StringValue(image.StateReason.Message)) != 0 {
+		message = fmt.Sprintf("%s: %s", message, aws.StringValue(image.StateReason.Message))
 	}
 
-	return parts[1], nil
+	return message
 }
 
-// imageMatchesInstanceGroup checks whether an EC2 image matches the instance group image name.
-func imageMatchesInstanceGroup(image *ec2.Image, imageName string) bool {
-	// Guard against nil inputs.
-	if image == nil {
-		return false
+// phaseError wraps a phase failure, calling out when the check deadline caused it.
+func phaseError(ctx context.Context, phase string, err error) error {
+	// Name the phase that ran out of time.
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("deadline exceeded during %s: %w", phase, err)
 	}
-	if len(imageName) == 0 {
-		return false
+
+	return err
+}
+
+// checkInstanceGroupCount fails when fewer instance groups than configured were found.
+func checkInstanceGroupCount(cfg *CheckConfig, instanceGroups []*kops.InstanceGroup) error {
+	// Compare the count with the configured minimum.
+	if len(instanceGroups) >= cfg.MinInstanceGroups {
+		return nil
 	}
 
-	// Check the EC2 image name field.
-	if image.Name != nil {
-		if strings.Contains(strings.TrimSpace(*image.Name), strings.TrimSpace(imageName)) {
-			log.Infoln("Found kops instance group image within list:", *image.Name)
-			return true
+	return fmt.Errorf("found %d instance groups under s3://%s/%s, expected at least %d; check AWS_S3_BUCKET_NAME, CLUSTER_FQDN, and bucket permissions",
+		len(instanceGroups), cfg.AWSS3BucketName, instanceGroupPrefix(cfg), cfg.MinInstanceGroups)
+}
+
+// checkImagesAreAvailable matches instance group image references against the AMIs found by their own lookups, explaining missing images when a diagnoser is given.
+func checkImagesAreAvailable(ctx context.Context, groupImages []*instanceGroupImage, images map[string][]*ec2.Image, matcher *imageMatcher, diagnoser *imageDiagnoser) ([]*imageMatch, []string) {
+	// Prepare the results.
+	matches := make([]*imageMatch, 0)
+	errorMessages := make([]string, 0)
+
+	// Iterate each instance group.
+	for _, groupImage := range groupImages {
+		// Skip nil entries defensively.
+		if groupImage == nil || groupImage.Group == nil || groupImage.Reference == nil {
+			continue
 		}
-	}
 
-	// Check the EC2 image location field.
-	if image.ImageLocation != nil {
-		if strings.Contains(strings.TrimSpace(*image.ImageLocation), strings.TrimSpace(imageName)) {
-			log.Infoln("Found kops instance group image within list:", *image.ImageLocation)
-			return true
+		log.Infoln("Looking at instance group:", groupImage.Group.Name, "in", groupImage.Region)
+
+		// Find the AMI satisfying the reference among the images its lookup returned.
+		candidates := images[imageLookupForReference(groupImage.Reference, groupImage.Region, matcher.Mode).key()]
+		image := matcher.match(groupImage.Reference, candidates)
+		if image == nil {
+			// Report images that exist but cannot be launched.
+			unavailable := matcher.matchUnavailable(groupImage.Reference, candidates)
+			if unavailable != nil {
+				errorMessages = append(errorMessages, unavailableImageMessage(groupImage, unavailable))
+				continue
+			}
+
+			message := fmt.Sprintf("[%s] could not find image matching %s for instance group %s", groupImage.Region, groupImage.Reference.String(), groupImage.Group.Name)
+			if diagnoser != nil {
+				reason := diagnoser.diagnose(ctx, groupImage)
+				if len(reason) != 0 {
+					message = fmt.Sprintf("%s: %s", message, reason)
+				}
+			}
+			errorMessages = append(errorMessages, message)
+			continue
 		}
+
+		// Record which image satisfied the instance group.
+		log.Infof("Instance group %s image %s is satisfied in %s by %s (name: %s, owner: %s).", groupImage.Group.Name,
+			groupImage.Reference.String(), groupImage.Region, aws.StringValue(image.ImageId), aws.StringValue(image.Name), aws.StringValue(image.OwnerId))
+		matches = append(matches, &imageMatch{
+			Group:     groupImage.Group,
+			Reference: groupImage.Reference,
+			Image:     image,
+			Region:    groupImage.Region,
+		})
 	}
 
-	return false
+	return matches, errorMessages
 }
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"k8s.io/kops/pkg/apis/kops"
 )
@@ -33,49 +38,66 @@ func buildImage(name string, location string) *ec2.Image {
 	return image
 }
 
-// TestExtractInstanceGroupImageNameWithOwner ensures owner/name is trimmed to name.
-func TestExtractInstanceGroupImageNameWithOwner(t *testing.T) {
+// TestInstanceGroupImageReferenceWithOwner ensures owner/name is split into owner and name.
+func TestInstanceGroupImageReferenceWithOwner(t *testing.T) {
 	// Build a kops instance group with owner/name format.
 	group := buildInstanceGroup("kope.io/k8s-1.27")
 
-	// Extract the image name.
-	name, err := extractInstanceGroupImageName(group)
+	// Classify the image reference.
+	ref, err := instanceGroupImageReference(group)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
 	// Validate the trimmed name.
-	if name != "k8s-1.27" {
-		t.Fatalf("expected image name k8s-1.27, got %s", name)
+	if ref.Name != "k8s-1.27" {
+		t.Fatalf("expected image name k8s-1.27, got %s", ref.Name)
 	}
 }
 
-// TestExtractInstanceGroupImageNameWithoutOwner keeps a plain image name intact.
-func TestExtractInstanceGroupImageNameWithoutOwner(t *testing.T) {
+// TestInstanceGroupImageReferenceWithoutOwner keeps a plain image name intact.
+func TestInstanceGroupImageReferenceWithoutOwner(t *testing.T) {
 	// Build a kops instance group with a plain image name.
 	group := buildInstanceGroup("k8s-1.27")
 
-	// Extract the image name.
-	name, err := extractInstanceGroupImageName(group)
+	// Classify the image reference.
+	ref, err := instanceGroupImageReference(group)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
 	// Validate the unmodified name.
-	if name != "k8s-1.27" {
-		t.Fatalf("expected image name k8s-1.27, got %s", name)
+	if ref.Name != "k8s-1.27" {
+		t.Fatalf("expected image name k8s-1.27, got %s", ref.Name)
 	}
 }
 
-// TestExtractInstanceGroupImageNameEmpty ensures empty image references error.
-func TestExtractInstanceGroupImageNameEmpty(t *testing.T) {
+// TestInstanceGroupImageReferenceEmpty ensures empty image references error.
+func TestInstanceGroupImageReferenceEmpty(t *testing.T) {
 	// Build a kops instance group with an empty image.
 	group := buildInstanceGroup("")
 
-	// Extract the image name.
-	name, err := extractInstanceGroupImageName(group)
+	// Classify the image reference.
+	ref, err := instanceGroupImageReference(group)
 	if err == nil {
-		t.Fatalf("expected error, got name %s", name)
+		t.Fatalf("expected error, got reference %v", ref)
+	}
+}
+
+// TestImageMatchesReferenceByID validates AMI ID matching.
+func TestImageMatchesReferenceByID(t *testing.T) {
+	// Build an EC2 image with an AMI ID.
+	image := buildImage("k8s-1.27", "")
+	image.ImageId = aws.String("ami-0abc123")
+
+	// Match against ID references.
+	matched := imageMatchesReference(image, &imageReference{Kind: imageReferenceID, ImageID: "ami-0abc123"})
+	if !matched {
+		t.Fatalf("expected match for image ID")
+	}
+	matched = imageMatchesReference(image, &imageReference{Kind: imageReferenceID, ImageID: "ami-0def456"})
+	if matched {
+		t.Fatalf("expected no match for a different image ID")
 	}
 }
 
@@ -111,3 +133,43 @@ func TestImageMatchesInstanceGroupNil(t *testing.T) {
 		t.Fatalf("expected nil image to not match")
 	}
 }
+
+// TestCheckInstanceGroupCount verifies the minimum instance group count is enforced.
+func TestCheckInstanceGroupCount(t *testing.T) {
+	// Require one instance group.
+	cfg := &CheckConfig{AWSS3BucketName: "bucket", ClusterName: "prod.k8s.local", MinInstanceGroups: 1}
+
+	// An empty cluster fails and names the searched prefix.
+	err := checkInstanceGroupCount(cfg, []*kops.InstanceGroup{})
+	if err == nil {
+		t.Fatalf("expected error for zero instance groups")
+	}
+	if !strings.Contains(err.Error(), "s3://bucket/prod.k8s.local/instancegroup/") {
+		t.Fatalf("expected error to name the searched prefix, got %s", err.Error())
+	}
+
+	// One instance group passes.
+	err = checkInstanceGroupCount(cfg, []*kops.InstanceGroup{buildInstanceGroup("kope.io/k8s-1.27")})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// TestPhaseErrorDeadline verifies deadline failures name the phase.
+func TestPhaseErrorDeadline(t *testing.T) {
+	// Build an already expired context.
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+
+	// The phase is named for deadline failures.
+	err := phaseError(ctx, "EC2 image lookup", fmt.Errorf("request canceled"))
+	if !strings.HasPrefix(err.Error(), "deadline exceeded during EC2 image lookup") {
+		t.Fatalf("expected deadline message, got %s", err.Error())
+	}
+
+	// Other failures pass through unchanged.
+	err = phaseError(context.Background(), "EC2 image lookup", fmt.Errorf("access denied"))
+	if err.Error() != "access denied" {
+		t.Fatalf("expected unchanged error, got %s", err.Error())
+	}
+}
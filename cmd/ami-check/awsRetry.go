@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// rateLimitHandlerName names the request handler that waits on the client side rate limiter.
+const rateLimitHandlerName = "amicheck.RateLimit"
+
+// awsRetryer retries failed AWS requests with jittered exponential backoff and records every retry.
+type awsRetryer struct {
+	client.DefaultRetryer
+	// stats records the retries of the run.
+	stats *retryStats
+}
+
+// newAWSRetryer builds the retryer from the check configuration.
+func newAWSRetryer(cfg *CheckConfig, stats *retryStats) *awsRetryer {
+	// Throttled requests back off from a longer minimum delay than other failures.
+	return &awsRetryer{
+		DefaultRetryer: client.DefaultRetryer{
+			NumMaxRetries:    cfg.AWSMaxRetries,
+			MinRetryDelay:    cfg.AWSRetryMinDelay,
+			MinThrottleDelay: cfg.AWSThrottleMinDelay,
+			MaxRetryDelay:    cfg.AWSRetryMaxDelay,
+			MaxThrottleDelay: cfg.AWSRetryMaxDelay,
+		},
+		stats: stats,
+	}
+}
+
+// RetryRules returns the jittered delay before the next attempt and records the retry.
+func (r *awsRetryer) RetryRules(req *request.Request) time.Duration {
+	// Compute the delay, which honors Retry-After for throttled requests.
+	delay := r.DefaultRetryer.RetryRules(req)
+	r.stats.record(req, delay)
+
+	return delay
+}
+
+// retryStats counts retried AWS requests by operation.
+type retryStats struct {
+	lock sync.Mutex
+	// retries counts retries by service and operation.
+	retries map[string]int
+	// total counts every retry.
+	total int
+	// throttled counts retries caused by throttling.
+	throttled int
+}
+
+// record counts a retry and logs it.
+func (s *retryStats) record(req *request.Request, delay time.Duration) {
+	// Name the operation by service so retries of each client are told apart.
+	operation := req.ClientInfo.ServiceName
+	if req.Operation != nil {
+		operation = operation + ":" + req.Operation.Name
+	}
+	throttled := req.IsErrorThrottle()
+
+	// Log the retry with the error that caused it.
+	code := awsErrorCode(req.Error)
+	if len(code) == 0 && req.Error != nil {
+		code = req.Error.Error()
+	}
+	log.Warnf("Retrying %s after %s (attempt %d, throttled %t): %s", operation, delay.Round(time.Millisecond), req.RetryCount+1, throttled, code)
+
+	// Count the retry.
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.retries == nil {
+		s.retries = make(map[string]int)
+	}
+	s.retries[operation]++
+	s.total++
+	if throttled {
+		s.throttled++
+	}
+}
+
+// summary describes the retries of the run, or returns nothing when no request was retried.
+func (s *retryStats) summary() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Skip runs without retries.
+	if s.total == 0 {
+		return nil
+	}
+
+	// List the operations in a stable order.
+	operations := make([]string, 0, len(s.retries))
+	for operation, count := range s.retries {
+		operations = append(operations, fmt.Sprintf("%s %d", operation, count))
+	}
+	sort.Strings(operations)
+
+	return []string{fmt.Sprintf("AWS requests were retried %d times, %d after throttling: %s", s.total, s.throttled, strings.Join(operations, ", "))}
+}
+
+// newRateLimiter builds the token bucket shared by the EC2 and S3 clients.
+func newRateLimiter(cfg *CheckConfig) *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(cfg.AWSRateLimit), cfg.AWSRateBurst)
+}
+
+// rateLimitHandler waits for a token before each request attempt is signed, including retries.
+func rateLimitHandler(limiter *rate.Limiter) request.NamedHandler {
+	return request.NamedHandler{
+		Name: rateLimitHandlerName,
+		Fn: func(r *request.Request) {
+			// Give up when the check deadline passes while waiting.
+			err := limiter.Wait(r.Context())
+			if err != nil {
+				r.Error = awserr.New(request.CanceledErrorCode, "request canceled while waiting for the client rate limiter", err)
+			}
+		},
+	}
+}
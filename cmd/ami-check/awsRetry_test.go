@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"golang.org/x/time/rate"
+)
+
+// buildAWSRequest builds a request for an operation that failed with an error code and HTTP status.
+func buildAWSRequest(service string, operation string, code string, status int, retryCount int) *request.Request {
+	req := request.New(aws.Config{}, metadata.ClientInfo{ServiceName: service, Endpoint: "https://example.com"}, request.Handlers{}, client.DefaultRetryer{},
+		&request.Operation{Name: operation, HTTPMethod: http.MethodPost, HTTPPath: "/"}, nil, nil)
+	if len(code) != 0 {
+		req.Error = awserr.New(code, "request failed", nil)
+		req.HTTPResponse = &http.Response{StatusCode: status, Header: http.Header{}}
+	}
+	req.RetryCount = retryCount
+
+	return req
+}
+
+// TestAWSRetryerBackoff verifies throttled and failed requests back off from their own delays and are counted.
+func TestAWSRetryerBackoff(t *testing.T) {
+	// Build the retryer with distinct delays.
+	stats := &retryStats{}
+	cfg := &CheckConfig{AWSMaxRetries: 5, AWSRetryMinDelay: time.Millisecond * 100, AWSThrottleMinDelay: time.Second, AWSRetryMaxDelay: time.Second * 20}
+	retryer := newAWSRetryer(cfg, stats)
+
+	// Throttled requests start from the throttle delay.
+	throttled := buildAWSRequest("ec2", "DescribeImages", "RequestLimitExceeded", http.StatusServiceUnavailable, 0)
+	if !retryer.ShouldRetry(throttled) {
+		t.Fatalf("expected throttled requests to be retried")
+	}
+	delay := retryer.RetryRules(throttled)
+	if delay < time.Second || delay >= time.Second*2 {
+		t.Fatalf("expected a throttle delay between one and two seconds, got %s", delay)
+	}
+
+	// Other failures start from the base delay.
+	delay = retryer.RetryRules(buildAWSRequest("s3", "GetObject", "InternalError", http.StatusInternalServerError, 0))
+	if delay < time.Millisecond*100 || delay >= time.Millisecond*200 {
+		t.Fatalf("expected a retry delay between 100ms and 200ms, got %s", delay)
+	}
+
+	// Later attempts are capped by the maximum delay.
+	delay = retryer.RetryRules(buildAWSRequest("ec2", "DescribeImages", "RequestLimitExceeded", http.StatusServiceUnavailable, 10))
+	if delay < time.Second*10 || delay >= time.Second*20 {
+		t.Fatalf("expected a capped delay between ten and twenty seconds, got %s", delay)
+	}
+
+	// Each retry is recorded by operation.
+	summary := stats.summary()
+	if len(summary) != 1 {
+		t.Fatalf("expected one summary, got %v", summary)
+	}
+	if !strings.Contains(summary[0], "retried 3 times, 2 after throttling") || !strings.Contains(summary[0], "ec2:DescribeImages 2, s3:GetObject 1") {
+		t.Fatalf("unexpected summary: %s", summary[0])
+	}
+
+	// Runs without retries have no summary.
+	if len((&retryStats{}).summary()) != 0 {
+		t.Fatalf("expected no summary without retries")
+	}
+}
+
+// TestRateLimitHandler verifies requests wait for a token and give up when their context ends.
+func TestRateLimitHandler(t *testing.T) {
+	// Allow a single request per hour.
+	handler := rateLimitHandler(rate.NewLimiter(rate.Every(time.Hour), 1))
+
+	// The first request takes the only token.
+	req := buildAWSRequest("ec2", "DescribeImages", "", 0, 0)
+	handler.Fn(req)
+	if req.Error != nil {
+		t.Fatalf("unexpected error: %v", req.Error)
+	}
+
+	// The next request is canceled at its deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	req = buildAWSRequest("ec2", "DescribeImages", "", 0, 0)
+	req.SetContext(ctx)
+	handler.Fn(req)
+	if awsErrorCode(req.Error) != request.CanceledErrorCode {
+		t.Fatalf("expected a canceled request, got %v", req.Error)
+	}
+}
@@ -1,21 +1,37 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
 )
 
-// createAWSSession builds a new AWS session for EC2 and S3 clients.
-func createAWSSession() (*session.Session, error) {
+// createAWSSession builds a new AWS session for EC2 and S3 clients that retries failed requests with the retryer.
+func createAWSSession(retryer request.Retryer) (*session.Session, error) {
 	// Log the session creation for visibility.
 	log.Infoln("Building AWS session.")
 
 	// Build a session with verbose credential chain errors.
 	cfg := aws.NewConfig()
 	cfg = cfg.WithCredentialsChainVerboseErrors(true)
+	cfg = request.WithRetryer(cfg, retryer)
 
 	awsSession, err := session.NewSession(cfg)
 	if err != nil {
@@ -24,3 +40,146 @@ func createAWSSession() (*session.Session, error) {
 
 	return awsSession, nil
 }
+
+// awsErrorCode returns the AWS error code of an error, or an empty string for other errors.
+func awsErrorCode(err error) string {
+	// Unwrap to the AWS error.
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		return awsErr.Code()
+	}
+
+	return ""
+}
+
+// awsClients builds region scoped AWS service clients and caches them per region.
+type awsClients struct {
+	// newS3 builds an S3 client for a region.
+	newS3 func(region string) s3iface.S3API
+	// newEC2 builds an EC2 client for a region.
+	newEC2 func(region string) ec2iface.EC2API
+	// newSSM builds an SSM client for a region.
+	newSSM func(region string) ssmiface.SSMAPI
+	// newSTS builds an STS client for a region.
+	newSTS func(region string) stsiface.STSAPI
+	// newKMS builds a KMS client for a region.
+	newKMS func(region string) kmsiface.KMSAPI
+
+	lock sync.Mutex
+	s3   map[string]s3iface.S3API
+	ec2  map[string]ec2iface.EC2API
+	ssm  map[string]ssmiface.SSMAPI
+	sts  map[string]stsiface.STSAPI
+	kms  map[string]kmsiface.KMSAPI
+}
+
+// newAWSClients builds the client factory backed by an AWS session, sharing the limiter between EC2 and S3 clients.
+func newAWSClients(awsSession *session.Session, limiter *rate.Limiter) *awsClients {
+	// Scope every client to the requested region.
+	return &awsClients{
+		newS3: func(region string) s3iface.S3API {
+			client := s3.New(awsSession, &aws.Config{Region: aws.String(region)})
+			client.Handlers.Sign.PushFrontNamed(rateLimitHandler(limiter))
+			return client
+		},
+		newEC2: func(region string) ec2iface.EC2API {
+			client := ec2.New(awsSession, &aws.Config{Region: aws.String(region)})
+			client.Handlers.Sign.PushFrontNamed(rateLimitHandler(limiter))
+			return client
+		},
+		newSSM: func(region string) ssmiface.SSMAPI {
+			return ssm.New(awsSession, &aws.Config{Region: aws.String(region)})
+		},
+		newSTS: func(region string) stsiface.STSAPI {
+			return sts.New(awsSession, &aws.Config{Region: aws.String(region)})
+		},
+		newKMS: func(region string) kmsiface.KMSAPI {
+			return kms.New(awsSession, &aws.Config{Region: aws.String(region)})
+		},
+	}
+}
+
+// s3Client returns the S3 client for a region.
+func (c *awsClients) s3Client(region string) s3iface.S3API {
+	// Build the client once per region.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.s3 == nil {
+		c.s3 = make(map[string]s3iface.S3API)
+	}
+	client, ok := c.s3[region]
+	if !ok {
+		client = c.newS3(region)
+		c.s3[region] = client
+	}
+
+	return client
+}
+
+// ec2Client returns the EC2 client for a region.
+func (c *awsClients) ec2Client(region string) ec2iface.EC2API {
+	// Build the client once per region.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.ec2 == nil {
+		c.ec2 = make(map[string]ec2iface.EC2API)
+	}
+	client, ok := c.ec2[region]
+	if !ok {
+		client = c.newEC2(region)
+		c.ec2[region] = client
+	}
+
+	return client
+}
+
+// ssmClient returns the SSM client for a region.
+func (c *awsClients) ssmClient(region string) ssmiface.SSMAPI {
+	// Build the client once per region.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.ssm == nil {
+		c.ssm = make(map[string]ssmiface.SSMAPI)
+	}
+	client, ok := c.ssm[region]
+	if !ok {
+		client = c.newSSM(region)
+		c.ssm[region] = client
+	}
+
+	return client
+}
+
+// stsClient returns the STS client for a region.
+func (c *awsClients) stsClient(region string) stsiface.STSAPI {
+	// Build the client once per region.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.sts == nil {
+		c.sts = make(map[string]stsiface.STSAPI)
+	}
+	client, ok := c.sts[region]
+	if !ok {
+		client = c.newSTS(region)
+		c.sts[region] = client
+	}
+
+	return client
+}
+
+// kmsClient returns the KMS client for a region.
+func (c *awsClients) kmsClient(region string) kmsiface.KMSAPI {
+	// Build the client once per region.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.kms == nil {
+		c.kms = make(map[string]kmsiface.KMSAPI)
+	}
+	client, ok := c.kms[region]
+	if !ok {
+		client = c.newKMS(region)
+		c.kms[region] = client
+	}
+
+	return client
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/kops/pkg/apis/kops"
+	"k8s.io/kops/pkg/apis/kops/util"
+	"k8s.io/kops/util/pkg/architectures"
+)
+
+// loadChannel reads a kops channel from a local file, an HTTP URL, or a channel name such as stable.
+func loadChannel(ctx context.Context, location string) (*kops.Channel, error) {
+	// Read the document from its location.
+	var channelBytes []byte
+	var err error
+	parsed, parseErr := url.Parse(location)
+	_, statErr := os.Stat(location)
+	switch {
+	case parseErr == nil && parsed.Scheme == "file":
+		channelBytes, err = os.ReadFile(parsed.Path)
+	case parseErr == nil && (parsed.Scheme == "http" || parsed.Scheme == "https"):
+		channelBytes, err = fetchChannel(ctx, location)
+	case statErr == nil || strings.HasPrefix(location, "/") || strings.HasPrefix(location, "."):
+		// Read existing files, including relative paths such as channels/stable.yaml, before treating the value as a channel name.
+		channelBytes, err = os.ReadFile(location)
+	default:
+		// Resolve channel names against the kops channel base, as kops does.
+		resolved, resolveErr := kops.ResolveChannel(location)
+		if resolveErr != nil {
+			return nil, resolveErr
+		}
+		if resolved == nil {
+			return nil, fmt.Errorf("channel location %s does not name a channel", location)
+		}
+		channelBytes, err = fetchChannel(ctx, resolved.String())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read channel %s: %w", location, err)
+	}
+
+	// Parse the channel document.
+	channel, err := kops.ParseChannel(channelBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse channel %s: %w", location, err)
+	}
+
+	log.Infoln("Loaded kops channel from", location, "with", len(channel.Spec.Images), "images.")
+	return channel, nil
+}
+
+// fetchChannel downloads a channel document over HTTP.
+func fetchChannel(ctx context.Context, location string) ([]byte, error) {
+	// Request the document within the check deadline.
+	log.Infoln("Downloading kops channel from", location)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	// Reject unsuccessful responses.
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status %s", response.Status)
+	}
+
+	return io.ReadAll(response.Body)
+}
+
+// checkChannelImages reports instance groups whose image differs from the channel recommendation for the cluster's Kubernetes version.
+func checkChannelImages(channel *kops.Channel, cluster *kops.Cluster, matches []*imageMatch) ([]string, error) {
+	// Parse the cluster's Kubernetes version.
+	kubernetesVersion, err := util.ParseKubernetesVersion(cluster.Spec.KubernetesVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse cluster Kubernetes version %q: %w", cluster.Spec.KubernetesVersion, err)
+	}
+
+	// Compare each matched image with the recommendation for its architecture.
+	errorMessages := make([]string, 0)
+	for _, match := range matches {
+		architecture := channelArchitecture(match.Image)
+		recommended := channel.FindImage(kops.CloudProviderAWS, *kubernetesVersion, architecture)
+		if recommended == nil {
+			log.Infof("Channel has no %s image for Kubernetes %s; skipping instance group %s.", architecture, kubernetesVersion, match.Group.Name)
+			continue
+		}
+
+		// The matched image must satisfy the recommended reference.
+		ref, err := parseImageReference(recommended.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse channel image %s: %w", recommended.Name, err)
+		}
+		if imageMatchesReference(match.Image, ref) {
+			continue
+		}
+		message := fmt.Sprintf("[%s] instance group %s image %s (%s) differs from the channel recommendation %s for Kubernetes %s",
+			match.Region, match.Group.Name, match.Reference.String(), aws.StringValue(match.Image.ImageId), recommended.Name, kubernetesVersion)
+		errorMessages = append(errorMessages, message)
+	}
+
+	return errorMessages, nil
+}
+
+// channelArchitecture maps an image architecture to the kops channel architecture.
+func channelArchitecture(image *ec2.Image) architectures.Architecture {
+	// Graviton images use the arm64 channel images, everything else amd64.
+	if aws.StringValue(image.Architecture) == ec2.ArchitectureValuesArm64 {
+		return architectures.ArchitectureArm64
+	}
+
+	return architectures.ArchitectureAmd64
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"k8s.io/kops/pkg/apis/kops"
+)
+
+// testChannel recommends Ubuntu images per architecture for Kubernetes 1.26 and later.
+const testChannel = `apiVersion: kops.k8s.io/v1alpha2
+kind: Channel
+spec:
+  images:
+  - name: 099720109477/ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-amd64-server-20231117
+    providerID: aws
+    architectureID: amd64
+    kubernetesVersion: ">=1.26.0"
+  - name: 099720109477/ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-arm64-server-20231117
+    providerID: aws
+    architectureID: arm64
+    kubernetesVersion: ">=1.26.0"
+`
+
+// TestLoadChannelSources verifies channels load from HTTP URLs and local files.
+func TestLoadChannelSources(t *testing.T) {
+	// Serve the channel over HTTP.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(testChannel))
+	}))
+	defer server.Close()
+	channel, err := loadChannel(context.Background(), server.URL+"/stable")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(channel.Spec.Images) != 2 {
+		t.Fatalf("expected two images, got %d", len(channel.Spec.Images))
+	}
+
+	// Read the channel from a file.
+	path := filepath.Join(t.TempDir(), "stable")
+	err = os.WriteFile(path, []byte(testChannel), 0o600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	channel, err = loadChannel(context.Background(), path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(channel.Spec.Images) != 2 {
+		t.Fatalf("expected two images, got %d", len(channel.Spec.Images))
+	}
+
+	// Read the channel from a relative path without a leading dot.
+	t.Chdir(filepath.Dir(path))
+	channel, err = loadChannel(context.Background(), filepath.Base(path))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(channel.Spec.Images) != 2 {
+		t.Fatalf("expected two images, got %d", len(channel.Spec.Images))
+	}
+
+	// Unsuccessful responses are errors.
+	_, err = loadChannel(context.Background(), server.URL+"/missing")
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+}
+
+// TestCheckChannelImages verifies images are compared with the recommendation for their architecture.
+func TestCheckChannelImages(t *testing.T) {
+	// Parse the channel and a cluster on Kubernetes 1.28.
+	channel, err := kops.ParseChannel([]byte(testChannel))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cluster := &kops.Cluster{}
+	cluster.Spec.KubernetesVersion = "1.28.2"
+
+	// Match one recommended arm64 image and one outdated amd64 image.
+	current := buildOwnedImage("ami-arm", "099720109477", "ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-arm64-server-20231117", "2023-11-17T00:00:00.000Z")
+	current.Architecture = aws.String(ec2.ArchitectureValuesArm64)
+	outdated := buildOwnedImage("ami-x86", "099720109477", "ubuntu/images/hvm-ssd/ubuntu-focal-20.04-amd64-server-20230101", "2023-01-01T00:00:00.000Z")
+	outdated.Architecture = aws.String(ec2.ArchitectureValuesX8664)
+	matches := []*imageMatch{
+		{Group: buildInstanceGroup("arm"), Reference: &imageReference{Raw: "arm"}, Image: current, Region: "us-east-1"},
+		{Group: buildInstanceGroup("x86"), Reference: &imageReference{Raw: "x86"}, Image: outdated, Region: "us-east-1"},
+	}
+
+	// Expect only the outdated image to drift.
+	drift, err := checkChannelImages(channel, cluster, matches)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(drift) != 1 || !strings.Contains(drift[0], "ubuntu-jammy-22.04-amd64-server-20231117 for Kubernetes 1.28.2") {
+		t.Fatalf("expected amd64 drift, got %v", drift)
+	}
+
+	// Unparseable versions are errors.
+	cluster.Spec.KubernetesVersion = "latest"
+	_, err = checkChannelImages(channel, cluster, matches)
+	if err == nil {
+		t.Fatalf("expected an error for an unparseable version")
+	}
+}
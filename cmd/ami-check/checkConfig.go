@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,8 +15,10 @@ import (
 const (
 	// awsRegionPattern validates AWS region strings.
 	awsRegionPattern = `^[\w]{2}[-][\w]{4,9}[-][\d]$`
-	// kopsStateStoreInstanceGroupKey is used to filter S3 object keys.
+	// kopsStateStoreInstanceGroupKey follows the cluster name in instance group object keys.
 	kopsStateStoreInstanceGroupKey = `/instancegroup/`
+	// kopsStateStoreClusterKey follows the cluster name in the cluster spec object key.
+	kopsStateStoreClusterKey = `/config`
 
 	// defaultAWSRegion is used when AWS_REGION is unset.
 	defaultAWSRegion = "us-east-1"
@@ -24,18 +27,106 @@ const (
 	// defaultClusterName is used when CLUSTER_FQDN is unset.
 	defaultClusterName = "cluster-fqdn"
 
+	// defaultImageMatchMode is used when IMAGE_MATCH_MODE is unset.
+	defaultImageMatchMode = imageMatchModeExact
+
+	// defaultImageLookupConcurrency is used when IMAGE_LOOKUP_CONCURRENCY is unset.
+	defaultImageLookupConcurrency = 4
+	// defaultStateStoreReadConcurrency is used when STATE_STORE_READ_CONCURRENCY is unset.
+	defaultStateStoreReadConcurrency = 8
+
+	// defaultAWSMaxRetries is used when AWS_MAX_RETRIES is unset.
+	defaultAWSMaxRetries = 5
+	// defaultAWSRetryMinDelay is used when AWS_RETRY_MIN_DELAY is unset.
+	defaultAWSRetryMinDelay = time.Millisecond * 100
+	// defaultAWSThrottleMinDelay is used when AWS_THROTTLE_MIN_DELAY is unset.
+	defaultAWSThrottleMinDelay = time.Second * 1
+	// defaultAWSRetryMaxDelay is used when AWS_RETRY_MAX_DELAY is unset.
+	defaultAWSRetryMaxDelay = time.Second * 20
+	// defaultAWSRateLimit is used when AWS_RATE_LIMIT is unset.
+	defaultAWSRateLimit = 20
+	// defaultAWSRateBurst is used when AWS_RATE_BURST is unset.
+	defaultAWSRateBurst = 20
+
+	// defaultMinInstanceGroups is used when MIN_INSTANCE_GROUPS is unset.
+	defaultMinInstanceGroups = 1
+
+	// defaultStateStoreErrorSeverity is used when STATE_STORE_ERROR_SEVERITY is unset.
+	defaultStateStoreErrorSeverity = severityFail
+
+	// defaultDeprecationWindow is used when DEPRECATION_WINDOW is unset.
+	defaultDeprecationWindow = time.Hour * 24 * 30
+	// defaultDeprecationWindowSeverity is used when DEPRECATION_WINDOW_SEVERITY is unset.
+	defaultDeprecationWindowSeverity = severityWarn
+	// defaultMaxImageAgeSeverity is used when MAX_IMAGE_AGE_SEVERITY is unset.
+	defaultMaxImageAgeSeverity = severityFail
+	// defaultChannelDriftSeverity is used when CHANNEL_DRIFT_SEVERITY is unset.
+	defaultChannelDriftSeverity = severityWarn
+
 	// defaultCheckTimeLimit is the fallback time limit for the check run.
 	defaultCheckTimeLimit = time.Minute * 1
 )
 
 // CheckConfig stores environment-driven configuration for the AMI check.
 type CheckConfig struct {
-	// AWSRegion selects the region for EC2 and S3 queries.
+	// AWSRegion is the default region for AWS queries.
 	AWSRegion string
+	// StateStoreRegion selects the region of the kops state store bucket.
+	StateStoreRegion string
+	// EC2Regions lists the regions to validate instance groups without zones in.
+	EC2Regions []string
 	// AWSS3BucketName identifies the kops state store bucket.
 	AWSS3BucketName string
 	// ClusterName filters kops instance group objects in S3.
 	ClusterName string
+	// RequireAvailableImage only accepts images in the available state.
+	RequireAvailableImage bool
+	// MinInstanceGroups is the fewest instance groups the state store must hold for the cluster.
+	MinInstanceGroups int
+	// StateStoreErrorSeverity decides whether unreadable instance group objects fail or warn.
+	StateStoreErrorSeverity string
+	// ImageMatchMode selects exact or legacy fuzzy image matching.
+	ImageMatchMode string
+	// ImageLookupConcurrency bounds concurrent EC2 image lookups.
+	ImageLookupConcurrency int
+	// StateStoreReadConcurrency bounds concurrent state store object reads.
+	StateStoreReadConcurrency int
+	// AWSMaxRetries is how many times a failed AWS request is retried.
+	AWSMaxRetries int
+	// AWSRetryMinDelay is the base backoff delay before retrying a failed AWS request.
+	AWSRetryMinDelay time.Duration
+	// AWSThrottleMinDelay is the base backoff delay before retrying a throttled AWS request.
+	AWSThrottleMinDelay time.Duration
+	// AWSRetryMaxDelay caps the backoff delay between AWS request attempts.
+	AWSRetryMaxDelay time.Duration
+	// AWSRateLimit is the sustained rate of EC2 and S3 requests per second.
+	AWSRateLimit int
+	// AWSRateBurst is how many EC2 and S3 requests may be sent at once above the sustained rate.
+	AWSRateBurst int
+	// ImageOwners is the allowlist of AMI owner account IDs and aliases; empty allows every owner.
+	ImageOwners []string
+	// DeprecationWindow is how far ahead to look for upcoming image deprecations.
+	DeprecationWindow time.Duration
+	// DeprecationWindowSeverity decides whether upcoming deprecations fail or warn.
+	DeprecationWindowSeverity string
+	// CheckLookalikeImages searches public images with similar names from untrusted owners.
+	CheckLookalikeImages bool
+	// CheckInstanceTypes verifies images can boot on their instance group's machine types.
+	CheckInstanceTypes bool
+	// CheckLaunchPermissions verifies the cluster account may launch each image.
+	CheckLaunchPermissions bool
+	// ClusterAccountID is the account the cluster launches nodes in; empty uses the calling account.
+	ClusterAccountID string
+	// MaxImageAge is the oldest an image may be; zero disables the policy.
+	MaxImageAge time.Duration
+	// MaxImageAgeSeverity is fail or warn for images older than the maximum age.
+	MaxImageAgeSeverity string
+	// ChannelLocation is the kops channel file, URL, or name to compare images with; empty disables the comparison.
+	ChannelLocation string
+	// ChannelDriftSeverity is fail or warn for images that differ from the channel recommendation.
+	ChannelDriftSeverity string
+	// DeepValidation inspects the EBS snapshots and KMS keys backing each image.
+	DeepValidation bool
 	// Debug enables verbose logging.
 	Debug bool
 	// CheckTimeLimit sets the allowed runtime for the check.
@@ -49,6 +140,23 @@ func parseConfig() (*CheckConfig, error) {
 	cfg.AWSRegion = defaultAWSRegion
 	cfg.AWSS3BucketName = defaultAWSS3BucketName
 	cfg.ClusterName = defaultClusterName
+	cfg.MinInstanceGroups = defaultMinInstanceGroups
+	cfg.StateStoreErrorSeverity = defaultStateStoreErrorSeverity
+	cfg.ImageMatchMode = defaultImageMatchMode
+	cfg.RequireAvailableImage = true
+	cfg.CheckInstanceTypes = true
+	cfg.ImageLookupConcurrency = defaultImageLookupConcurrency
+	cfg.StateStoreReadConcurrency = defaultStateStoreReadConcurrency
+	cfg.AWSMaxRetries = defaultAWSMaxRetries
+	cfg.AWSRetryMinDelay = defaultAWSRetryMinDelay
+	cfg.AWSThrottleMinDelay = defaultAWSThrottleMinDelay
+	cfg.AWSRetryMaxDelay = defaultAWSRetryMaxDelay
+	cfg.AWSRateLimit = defaultAWSRateLimit
+	cfg.AWSRateBurst = defaultAWSRateBurst
+	cfg.DeprecationWindow = defaultDeprecationWindow
+	cfg.DeprecationWindowSeverity = defaultDeprecationWindowSeverity
+	cfg.MaxImageAgeSeverity = defaultMaxImageAgeSeverity
+	cfg.ChannelDriftSeverity = defaultChannelDriftSeverity
 	cfg.CheckTimeLimit = defaultCheckTimeLimit
 
 	// Parse debug settings first so logs are verbose when needed.
@@ -78,6 +186,31 @@ func parseConfig() (*CheckConfig, error) {
 		cfg.AWSRegion = regionEnv
 	}
 
+	// Parse AWS_STATE_STORE_REGION, defaulting to AWS_REGION.
+	cfg.StateStoreRegion = cfg.AWSRegion
+	stateStoreRegionEnv := os.Getenv("AWS_STATE_STORE_REGION")
+	if len(stateStoreRegionEnv) != 0 {
+		regions, err := parseRegionList("AWS_STATE_STORE_REGION", stateStoreRegionEnv)
+		if err != nil {
+			return nil, err
+		}
+		if len(regions) != 1 {
+			return nil, fmt.Errorf("AWS_STATE_STORE_REGION must name a single region")
+		}
+		cfg.StateStoreRegion = regions[0]
+	}
+
+	// Parse AWS_EC2_REGIONS, defaulting to AWS_REGION.
+	cfg.EC2Regions = []string{cfg.AWSRegion}
+	ec2RegionsEnv := os.Getenv("AWS_EC2_REGIONS")
+	if len(ec2RegionsEnv) != 0 {
+		regions, err := parseRegionList("AWS_EC2_REGIONS", ec2RegionsEnv)
+		if err != nil {
+			return nil, err
+		}
+		cfg.EC2Regions = regions
+	}
+
 	// Parse AWS_S3_BUCKET_NAME.
 	bucketEnv := os.Getenv("AWS_S3_BUCKET_NAME")
 	if len(bucketEnv) != 0 {
@@ -90,6 +223,247 @@ func parseConfig() (*CheckConfig, error) {
 		cfg.ClusterName = clusterEnv
 	}
 
+	// Parse MIN_INSTANCE_GROUPS.
+	minGroupsEnv := os.Getenv("MIN_INSTANCE_GROUPS")
+	if len(minGroupsEnv) != 0 {
+		minGroups, err := parsePositiveInt("MIN_INSTANCE_GROUPS", minGroupsEnv)
+		if err != nil {
+			return nil, err
+		}
+		cfg.MinInstanceGroups = minGroups
+	}
+
+	// Parse STATE_STORE_ERROR_SEVERITY.
+	stateStoreSeverityEnv := os.Getenv("STATE_STORE_ERROR_SEVERITY")
+	if len(stateStoreSeverityEnv) != 0 {
+		severity, err := parseSeverity("STATE_STORE_ERROR_SEVERITY", stateStoreSeverityEnv)
+		if err != nil {
+			return nil, err
+		}
+		cfg.StateStoreErrorSeverity = severity
+	}
+
+	// Parse IMAGE_MATCH_MODE.
+	matchModeEnv := os.Getenv("IMAGE_MATCH_MODE")
+	if len(matchModeEnv) != 0 {
+		matchMode, err := parseImageMatchMode(matchModeEnv)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ImageMatchMode = matchMode
+	}
+
+	// Parse REQUIRE_AVAILABLE_IMAGE.
+	requireAvailableEnv := os.Getenv("REQUIRE_AVAILABLE_IMAGE")
+	if len(requireAvailableEnv) != 0 {
+		enabled, err := parseBoolValue("REQUIRE_AVAILABLE_IMAGE", requireAvailableEnv)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RequireAvailableImage = enabled
+	}
+
+	// Parse IMAGE_LOOKUP_CONCURRENCY.
+	lookupConcurrencyEnv := os.Getenv("IMAGE_LOOKUP_CONCURRENCY")
+	if len(lookupConcurrencyEnv) != 0 {
+		concurrency, err := parsePositiveInt("IMAGE_LOOKUP_CONCURRENCY", lookupConcurrencyEnv)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ImageLookupConcurrency = concurrency
+	}
+
+	// Parse STATE_STORE_READ_CONCURRENCY.
+	readConcurrencyEnv := os.Getenv("STATE_STORE_READ_CONCURRENCY")
+	if len(readConcurrencyEnv) != 0 {
+		concurrency, err := parsePositiveInt("STATE_STORE_READ_CONCURRENCY", readConcurrencyEnv)
+		if err != nil {
+			return nil, err
+		}
+		cfg.StateStoreReadConcurrency = concurrency
+	}
+
+	// Parse AWS_MAX_RETRIES.
+	maxRetriesEnv := os.Getenv("AWS_MAX_RETRIES")
+	if len(maxRetriesEnv) != 0 {
+		maxRetries, err := parsePositiveInt("AWS_MAX_RETRIES", maxRetriesEnv)
+		if err != nil {
+			return nil, err
+		}
+		cfg.AWSMaxRetries = maxRetries
+	}
+
+	// Parse AWS_RETRY_MIN_DELAY.
+	retryMinDelayEnv := os.Getenv("AWS_RETRY_MIN_DELAY")
+	if len(retryMinDelayEnv) != 0 {
+		delay, err := parseDuration("AWS_RETRY_MIN_DELAY", retryMinDelayEnv)
+		if err != nil {
+			return nil, err
+		}
+		cfg.AWSRetryMinDelay = delay
+	}
+
+	// Parse AWS_THROTTLE_MIN_DELAY.
+	throttleMinDelayEnv := os.Getenv("AWS_THROTTLE_MIN_DELAY")
+	if len(throttleMinDelayEnv) != 0 {
+		delay, err := parseDuration("AWS_THROTTLE_MIN_DELAY", throttleMinDelayEnv)
+		if err != nil {
+			return nil, err
+		}
+		cfg.AWSThrottleMinDelay = delay
+	}
+
+	// Parse AWS_RETRY_MAX_DELAY.
+	retryMaxDelayEnv := os.Getenv("AWS_RETRY_MAX_DELAY")
+	if len(retryMaxDelayEnv) != 0 {
+		delay, err := parseDuration("AWS_RETRY_MAX_DELAY", retryMaxDelayEnv)
+		if err != nil {
+			return nil, err
+		}
+		cfg.AWSRetryMaxDelay = delay
+	}
+
+	// Reject a maximum delay below either minimum delay.
+	if cfg.AWSRetryMaxDelay < cfg.AWSRetryMinDelay || cfg.AWSRetryMaxDelay < cfg.AWSThrottleMinDelay {
+		return nil, fmt.Errorf("AWS_RETRY_MAX_DELAY must not be less than AWS_RETRY_MIN_DELAY or AWS_THROTTLE_MIN_DELAY")
+	}
+
+	// Parse AWS_RATE_LIMIT.
+	rateLimitEnv := os.Getenv("AWS_RATE_LIMIT")
+	if len(rateLimitEnv) != 0 {
+		rateLimit, err := parsePositiveInt("AWS_RATE_LIMIT", rateLimitEnv)
+		if err != nil {
+			return nil, err
+		}
+		cfg.AWSRateLimit = rateLimit
+	}
+
+	// Parse AWS_RATE_BURST.
+	rateBurstEnv := os.Getenv("AWS_RATE_BURST")
+	if len(rateBurstEnv) != 0 {
+		rateBurst, err := parsePositiveInt("AWS_RATE_BURST", rateBurstEnv)
+		if err != nil {
+			return nil, err
+		}
+		cfg.AWSRateBurst = rateBurst
+	}
+
+	// Parse AMI_OWNERS.
+	ownersEnv := os.Getenv("AMI_OWNERS")
+	if len(ownersEnv) != 0 {
+		owners, err := parseImageOwners(ownersEnv)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ImageOwners = owners
+	}
+
+	// Parse DEPRECATION_WINDOW.
+	deprecationWindowEnv := os.Getenv("DEPRECATION_WINDOW")
+	if len(deprecationWindowEnv) != 0 {
+		window, err := parseDuration("DEPRECATION_WINDOW", deprecationWindowEnv)
+		if err != nil {
+			return nil, err
+		}
+		cfg.DeprecationWindow = window
+	}
+
+	// Parse DEPRECATION_WINDOW_SEVERITY.
+	deprecationSeverityEnv := os.Getenv("DEPRECATION_WINDOW_SEVERITY")
+	if len(deprecationSeverityEnv) != 0 {
+		severity, err := parseSeverity("DEPRECATION_WINDOW_SEVERITY", deprecationSeverityEnv)
+		if err != nil {
+			return nil, err
+		}
+		cfg.DeprecationWindowSeverity = severity
+	}
+
+	// Parse CHECK_LOOKALIKE_IMAGES.
+	lookalikeEnv := os.Getenv("CHECK_LOOKALIKE_IMAGES")
+	if len(lookalikeEnv) != 0 {
+		enabled, err := parseBoolValue("CHECK_LOOKALIKE_IMAGES", lookalikeEnv)
+		if err != nil {
+			return nil, err
+		}
+		cfg.CheckLookalikeImages = enabled
+	}
+
+	// Parse CHECK_INSTANCE_TYPES.
+	instanceTypesEnv := os.Getenv("CHECK_INSTANCE_TYPES")
+	if len(instanceTypesEnv) != 0 {
+		enabled, err := parseBoolValue("CHECK_INSTANCE_TYPES", instanceTypesEnv)
+		if err != nil {
+			return nil, err
+		}
+		cfg.CheckInstanceTypes = enabled
+	}
+
+	// Parse CHECK_LAUNCH_PERMISSIONS.
+	launchPermissionsEnv := os.Getenv("CHECK_LAUNCH_PERMISSIONS")
+	if len(launchPermissionsEnv) != 0 {
+		enabled, err := parseBoolValue("CHECK_LAUNCH_PERMISSIONS", launchPermissionsEnv)
+		if err != nil {
+			return nil, err
+		}
+		cfg.CheckLaunchPermissions = enabled
+	}
+
+	// Parse CLUSTER_AWS_ACCOUNT_ID.
+	clusterAccountEnv := strings.TrimSpace(os.Getenv("CLUSTER_AWS_ACCOUNT_ID"))
+	if len(clusterAccountEnv) != 0 {
+		matched, err := regexp.MatchString(awsAccountIDPattern, clusterAccountEnv)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CLUSTER_AWS_ACCOUNT_ID: %w", err)
+		}
+		if !matched {
+			return nil, fmt.Errorf("CLUSTER_AWS_ACCOUNT_ID must be a twelve digit account ID")
+		}
+		cfg.ClusterAccountID = clusterAccountEnv
+	}
+
+	// Parse MAX_IMAGE_AGE.
+	maxImageAgeEnv := os.Getenv("MAX_IMAGE_AGE")
+	if len(maxImageAgeEnv) != 0 {
+		maxAge, err := parseDuration("MAX_IMAGE_AGE", maxImageAgeEnv)
+		if err != nil {
+			return nil, err
+		}
+		cfg.MaxImageAge = maxAge
+	}
+
+	// Parse MAX_IMAGE_AGE_SEVERITY.
+	maxImageAgeSeverityEnv := os.Getenv("MAX_IMAGE_AGE_SEVERITY")
+	if len(maxImageAgeSeverityEnv) != 0 {
+		severity, err := parseSeverity("MAX_IMAGE_AGE_SEVERITY", maxImageAgeSeverityEnv)
+		if err != nil {
+			return nil, err
+		}
+		cfg.MaxImageAgeSeverity = severity
+	}
+
+	// Parse CHANNEL_LOCATION.
+	cfg.ChannelLocation = strings.TrimSpace(os.Getenv("CHANNEL_LOCATION"))
+
+	// Parse CHANNEL_DRIFT_SEVERITY.
+	channelSeverityEnv := os.Getenv("CHANNEL_DRIFT_SEVERITY")
+	if len(channelSeverityEnv) != 0 {
+		severity, err := parseSeverity("CHANNEL_DRIFT_SEVERITY", channelSeverityEnv)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ChannelDriftSeverity = severity
+	}
+
+	// Parse DEEP_VALIDATION.
+	deepValidationEnv := os.Getenv("DEEP_VALIDATION")
+	if len(deepValidationEnv) != 0 {
+		enabled, err := parseBoolValue("DEEP_VALIDATION", deepValidationEnv)
+		if err != nil {
+			return nil, err
+		}
+		cfg.DeepValidation = enabled
+	}
+
 	// Parse deadline from Kuberhealthy.
 	deadline, err := checkclient.GetDeadline()
 	if err == nil {
@@ -113,7 +487,91 @@ func validateAWSRegion(value string) (bool, error) {
 	return ok, nil
 }
 
-// parseDebugValue interprets DEBUG values without strconv to avoid multi-arch issues.
+// parseRegionList parses a comma separated list of AWS regions.
+func parseRegionList(name string, value string) ([]string, error) {
+	// Validate each region.
+	regions := make([]string, 0)
+	for _, entry := range strings.Split(value, ",") {
+		region := strings.TrimSpace(entry)
+		if len(region) == 0 {
+			continue
+		}
+		valid, err := validateAWSRegion(region)
+		if err != nil {
+			return nil, err
+		}
+		if !valid {
+			return nil, fmt.Errorf("%s entry %s does not match expected format", name, region)
+		}
+		regions = append(regions, region)
+	}
+
+	// Reject lists without any regions.
+	if len(regions) == 0 {
+		return nil, fmt.Errorf("%s does not list any regions", name)
+	}
+
+	return regions, nil
+}
+
+// parseImageMatchMode validates the image matching mode.
+func parseImageMatchMode(value string) (string, error) {
+	// Normalize the input string.
+	normalized := strings.ToLower(strings.TrimSpace(value))
+
+	// Accept the known modes.
+	if normalized == imageMatchModeExact {
+		return normalized, nil
+	}
+	if normalized == imageMatchModeFuzzy {
+		return normalized, nil
+	}
+
+	return "", fmt.Errorf("IMAGE_MATCH_MODE must be %s or %s, got %s", imageMatchModeExact, imageMatchModeFuzzy, value)
+}
+
+// parsePositiveInt parses a positive integer setting.
+func parsePositiveInt(name string, value string) (int, error) {
+	// Parse the trimmed value.
+	parsed, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	if parsed < 1 {
+		return 0, fmt.Errorf("%s must be at least 1, got %d", name, parsed)
+	}
+
+	return parsed, nil
+}
+
+// parseDuration parses a Go duration or a whole number of days such as 30d.
+func parseDuration(name string, value string) (time.Duration, error) {
+	// Handle day counts, which time.ParseDuration does not support.
+	trimmed := strings.TrimSpace(value)
+	if strings.HasSuffix(trimmed, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(trimmed, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+		if days < 0 {
+			return 0, fmt.Errorf("%s must not be negative, got %s", name, value)
+		}
+		return time.Hour * 24 * time.Duration(days), nil
+	}
+
+	// Handle Go durations.
+	duration, err := time.ParseDuration(trimmed)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	if duration < 0 {
+		return 0, fmt.Errorf("%s must not be negative, got %s", name, value)
+	}
+
+	return duration, nil
+}
+
+// parseDebugValue interprets DEBUG values, treating anything but a truthy value as false.
 func parseDebugValue(value string) bool {
 	// Normalize the input string.
 	normalized := strings.ToLower(strings.TrimSpace(value))
@@ -131,3 +589,23 @@ func parseDebugValue(value string) bool {
 
 	return false
 }
+
+// parseBoolValue parses a boolean setting, accepting the strconv.ParseBool values as well as yes and no.
+func parseBoolValue(name string, value string) (bool, error) {
+	// Normalize the input string.
+	normalized := strings.ToLower(strings.TrimSpace(value))
+
+	// Accept yes and no alongside the strconv values.
+	if normalized == "yes" {
+		return true, nil
+	}
+	if normalized == "no" {
+		return false, nil
+	}
+	parsed, err := strconv.ParseBool(normalized)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false, got %s", name, value)
+	}
+
+	return parsed, nil
+}
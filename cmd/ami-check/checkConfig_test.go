@@ -1,6 +1,9 @@
 package main
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 // TestValidateAWSRegion verifies region validation behavior.
 func TestValidateAWSRegion(t *testing.T) {
@@ -44,3 +47,106 @@ func TestParseDebugValue(t *testing.T) {
 		t.Fatalf("expected no to be parsed as false")
 	}
 }
+
+// TestParseBoolValue verifies boolean settings accept strconv and yes/no values and reject anything else.
+func TestParseBoolValue(t *testing.T) {
+	// Validate accepted values.
+	for value, expected := range map[string]bool{"1": true, " TRUE ": true, "yes": true, "0": false, "f": false, "No": false} {
+		parsed, err := parseBoolValue("SETTING", value)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", value, err)
+		}
+		if parsed != expected {
+			t.Fatalf("expected %q to be parsed as %t", value, expected)
+		}
+	}
+
+	// Validate unknown values.
+	for _, value := range []string{"on", "ture", ""} {
+		_, err := parseBoolValue("SETTING", value)
+		if err == nil {
+			t.Fatalf("expected error for %q", value)
+		}
+	}
+}
+
+// TestParseImageMatchMode verifies image match mode parsing.
+func TestParseImageMatchMode(t *testing.T) {
+	// Validate known modes.
+	mode, err := parseImageMatchMode("Fuzzy")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mode != imageMatchModeFuzzy {
+		t.Fatalf("expected fuzzy mode, got %s", mode)
+	}
+
+	// Validate unknown modes.
+	_, err = parseImageMatchMode("loose")
+	if err == nil {
+		t.Fatalf("expected error for unknown mode")
+	}
+}
+
+// TestParseSeverity verifies severity parsing.
+func TestParseSeverity(t *testing.T) {
+	// Validate known severities.
+	severity, err := parseSeverity("SEVERITY", " WARN ")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if severity != severityWarn {
+		t.Fatalf("expected warn, got %s", severity)
+	}
+
+	// Validate unknown severities.
+	_, err = parseSeverity("SEVERITY", "ignore")
+	if err == nil {
+		t.Fatalf("expected error for unknown severity")
+	}
+}
+
+// TestParseRegionList verifies region list parsing.
+func TestParseRegionList(t *testing.T) {
+	// Validate a list of regions.
+	regions, err := parseRegionList("AWS_EC2_REGIONS", "us-east-1, eu-west-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(regions) != 2 || regions[1] != "eu-west-1" {
+		t.Fatalf("unexpected regions: %v", regions)
+	}
+
+	// Validate malformed entries.
+	_, err = parseRegionList("AWS_EC2_REGIONS", "us-east-1,invalid")
+	if err == nil {
+		t.Fatalf("expected error for invalid region")
+	}
+}
+
+// TestParseDuration verifies Go durations and day counts are accepted.
+func TestParseDuration(t *testing.T) {
+	// Validate a day count.
+	duration, err := parseDuration("WINDOW", "30d")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if duration != time.Hour*24*30 {
+		t.Fatalf("expected 30 days, got %s", duration)
+	}
+
+	// Validate a Go duration.
+	duration, err = parseDuration("WINDOW", "36h")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if duration != time.Hour*36 {
+		t.Fatalf("expected 36 hours, got %s", duration)
+	}
+
+	// Validate malformed values.
+	_, err = parseDuration("WINDOW", "soon")
+	if err == nil {
+		t.Fatalf("expected error for malformed duration")
+	}
+}
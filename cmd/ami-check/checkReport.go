@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// severityFail reports a finding as a check failure.
+	severityFail = "fail"
+	// severityWarn logs a finding as a warning without failing the check.
+	severityWarn = "warn"
+)
+
+// checkReport collects the findings of a check run for Kuberhealthy.
+type checkReport struct {
+	// Failures fail the check.
+	Failures []string
+	// Warnings are logged and listed alongside failures.
+	Warnings []string
+}
+
+// newCheckReport builds an empty report.
+func newCheckReport() *checkReport {
+	return &checkReport{
+		Failures: make([]string, 0),
+		Warnings: make([]string, 0),
+	}
+}
+
+// fail records findings that fail the check.
+func (r *checkReport) fail(messages ...string) {
+	r.Failures = append(r.Failures, messages...)
+}
+
+// warn records findings that do not fail the check.
+func (r *checkReport) warn(messages ...string) {
+	r.Warnings = append(r.Warnings, messages...)
+}
+
+// add records findings with a configured severity.
+func (r *checkReport) add(severity string, messages ...string) {
+	// Route the findings by severity.
+	if severity == severityWarn {
+		r.warn(messages...)
+		return
+	}
+
+	r.fail(messages...)
+}
+
+// failed reports whether any finding fails the check.
+func (r *checkReport) failed() bool {
+	return len(r.Failures) != 0
+}
+
+// messages renders failures followed by warnings for the Kuberhealthy report.
+func (r *checkReport) messages() []string {
+	// Keep failures first so they lead the report.
+	results := make([]string, 0, len(r.Failures)+len(r.Warnings))
+	results = append(results, r.Failures...)
+	for _, warning := range r.Warnings {
+		results = append(results, "warning: "+warning)
+	}
+
+	return results
+}
+
+// logWarnings writes each warning to the check log.
+func (r *checkReport) logWarnings() {
+	for _, warning := range r.Warnings {
+		log.Warnln(warning)
+	}
+}
+
+// parseSeverity validates a fail or warn severity setting.
+func parseSeverity(name string, value string) (string, error) {
+	// Normalize the input string.
+	normalized := strings.ToLower(strings.TrimSpace(value))
+
+	// Accept the known severities.
+	if normalized == severityFail {
+		return normalized, nil
+	}
+	if normalized == severityWarn {
+		return normalized, nil
+	}
+
+	return "", fmt.Errorf("%s must be %s or %s, got %s", name, severityFail, severityWarn, value)
+}
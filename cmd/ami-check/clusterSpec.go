@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/kops/pkg/apis/kops"
+)
+
+// checkClusterSpec reports cluster spec problems that make its instance groups unreliable to validate.
+func checkClusterSpec(cfg *CheckConfig, cluster *kops.Cluster, groups []*kops.InstanceGroup) []string {
+	// Prepare the error list.
+	errorMessages := make([]string, 0)
+
+	// The spec must describe the configured cluster.
+	if cluster.Name != cfg.ClusterName {
+		errorMessages = append(errorMessages, fmt.Sprintf("cluster spec under %s describes cluster %s", cfg.ClusterName, cluster.Name))
+	}
+
+	// Only AWS clusters launch EC2 images.
+	provider := cluster.Spec.GetCloudProvider()
+	if provider != kops.CloudProviderAWS {
+		errorMessages = append(errorMessages, fmt.Sprintf("cluster %s uses cloud provider %s, but only AWS clusters can be validated", cfg.ClusterName, provider))
+	}
+
+	// Every subnet an instance group uses must be defined by the cluster.
+	subnetZones := clusterSubnetZones(cluster)
+	for _, group := range groups {
+		for _, subnet := range group.Spec.Subnets {
+			_, ok := subnetZones[subnet]
+			if !ok {
+				errorMessages = append(errorMessages, fmt.Sprintf("instance group %s uses subnet %s, which is not defined in the cluster spec", group.Name, subnet))
+			}
+		}
+	}
+
+	return errorMessages
+}
+
+// applyClusterDefaults fills instance group settings that kops derives from the cluster spec.
+func applyClusterDefaults(cluster *kops.Cluster, groups []*kops.InstanceGroup) {
+	// Map subnets to their zones.
+	subnetZones := clusterSubnetZones(cluster)
+
+	// Update each instance group.
+	for _, group := range groups {
+		// Groups that only list subnets launch in the zones of those subnets.
+		if len(group.Spec.Zones) == 0 {
+			seen := make(map[string]bool)
+			for _, subnet := range group.Spec.Subnets {
+				zone := subnetZones[subnet]
+				if len(zone) == 0 || seen[zone] {
+					continue
+				}
+				seen[zone] = true
+				group.Spec.Zones = append(group.Spec.Zones, zone)
+			}
+			log.Debugln("Instance group", group.Name, "launches in zones", group.Spec.Zones)
+		}
+
+		// Apply the cluster's default warm pool.
+		if cluster.Spec.CloudProvider.AWS != nil {
+			group.Spec.WarmPool = cluster.Spec.CloudProvider.AWS.WarmPool.ResolveDefaults(group)
+		}
+	}
+}
+
+// clusterSubnetZones maps each cluster subnet name to its zone.
+func clusterSubnetZones(cluster *kops.Cluster) map[string]string {
+	// Index the subnets by name.
+	subnetZones := make(map[string]string)
+	for _, subnet := range cluster.Spec.Networking.Subnets {
+		subnetZones[subnet.Name] = subnet.Zone
+	}
+
+	return subnetZones
+}
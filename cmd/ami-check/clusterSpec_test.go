@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/kops/pkg/apis/kops"
+)
+
+// buildClusterYAML renders a minimal AWS cluster document with two subnets and a default warm pool.
+func buildClusterYAML(name string) string {
+	return "apiVersion: kops.k8s.io/v1alpha2\nkind: Cluster\nmetadata:\n  name: " + name +
+		"\nspec:\n  cloudProvider: aws\n  kubernetesVersion: 1.28.2\n  warmPool:\n    minSize: 1\n" +
+		"  subnets:\n  - name: private-a\n    zone: us-east-1a\n    type: Private\n  - name: private-b\n    zone: us-east-1b\n    type: Private\n"
+}
+
+// TestDecodeKopsCluster verifies versioned cluster documents are converted to the internal type.
+func TestDecodeKopsCluster(t *testing.T) {
+	// Decode the cluster.
+	cluster, err := decodeKopsCluster([]byte(buildClusterYAML("prod.k8s.local")))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cluster.Name != "prod.k8s.local" || cluster.Spec.GetCloudProvider() != kops.CloudProviderAWS {
+		t.Fatalf("unexpected cluster: %s %s", cluster.Name, cluster.Spec.GetCloudProvider())
+	}
+	if cluster.Spec.CloudProvider.AWS.WarmPool == nil || cluster.Spec.CloudProvider.AWS.WarmPool.MinSize != 1 {
+		t.Fatalf("expected the default warm pool to be converted, got %v", cluster.Spec.CloudProvider.AWS.WarmPool)
+	}
+
+	// Instance group documents are not clusters.
+	_, err = decodeKopsCluster([]byte(buildInstanceGroupYAML("nodes", "kope.io/k8s-1.27")))
+	if err == nil {
+		t.Fatalf("expected an error decoding an instance group as a cluster")
+	}
+}
+
+// TestCheckClusterSpec verifies the cluster name and instance group subnets are validated.
+func TestCheckClusterSpec(t *testing.T) {
+	// Decode a cluster stored under the wrong name.
+	cluster, err := decodeKopsCluster([]byte(buildClusterYAML("other.k8s.local")))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	group := buildInstanceGroup("kope.io/k8s-1.27")
+	group.Spec.Subnets = []string{"private-a", "private-z"}
+	cfg := &CheckConfig{ClusterName: "prod.k8s.local"}
+
+	// Expect the name and the unknown subnet to be reported.
+	errorMessages := checkClusterSpec(cfg, cluster, []*kops.InstanceGroup{group})
+	if len(errorMessages) != 2 {
+		t.Fatalf("expected two errors, got %v", errorMessages)
+	}
+	if !strings.Contains(errorMessages[1], "subnet private-z") {
+		t.Fatalf("expected the unknown subnet to be reported, got %s", errorMessages[1])
+	}
+}
+
+// TestApplyClusterDefaults verifies subnet zones and the default warm pool are applied.
+func TestApplyClusterDefaults(t *testing.T) {
+	// Decode the cluster.
+	cluster, err := decodeKopsCluster([]byte(buildClusterYAML("prod.k8s.local")))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Build a node group that only lists subnets and a control plane group with zones.
+	nodes := buildInstanceGroup("kope.io/k8s-1.27")
+	nodes.Spec.Role = kops.InstanceGroupRoleNode
+	nodes.Spec.Subnets = []string{"private-a", "private-b", "private-a"}
+	controlPlane := buildInstanceGroup("kope.io/k8s-1.27")
+	controlPlane.Spec.Role = kops.InstanceGroupRoleControlPlane
+	controlPlane.Spec.Zones = []string{"us-east-1c"}
+
+	// Apply the defaults.
+	applyClusterDefaults(cluster, []*kops.InstanceGroup{nodes, controlPlane})
+	if strings.Join(nodes.Spec.Zones, ",") != "us-east-1a,us-east-1b" {
+		t.Fatalf("expected subnet zones, got %v", nodes.Spec.Zones)
+	}
+	if !nodes.Spec.WarmPool.IsEnabled() {
+		t.Fatalf("expected nodes to inherit the warm pool")
+	}
+	if strings.Join(controlPlane.Spec.Zones, ",") != "us-east-1c" || controlPlane.Spec.WarmPool.IsEnabled() {
+		t.Fatalf("expected control plane zones to be kept without a warm pool")
+	}
+}
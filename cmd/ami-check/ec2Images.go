@@ -1,49 +1,269 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	log "github.com/sirupsen/logrus"
 )
 
 const (
-	// wellKnownAccountKopeio is the kops account ID.
-	wellKnownAccountKopeio = "383156758163"
-	// wellKnownAccountRedhat is the Red Hat account ID.
-	wellKnownAccountRedhat = "309956199498"
-	// wellKnownAccountCoreOS is the CoreOS account ID.
-	wellKnownAccountCoreOS = "595879546273"
-	// wellKnownAccountAmazonLinux2 is the Amazon Linux 2 account ID.
-	wellKnownAccountAmazonLinux2 = "137112412989"
+	// describeImagesPageSize is the MaxResults of each DescribeImages page.
+	describeImagesPageSize = 100
 )
 
-// listEC2Images queries EC2 for available AMIs from trusted owners.
-func listEC2Images(cfg *CheckConfig, awsSession *session.Session) ([]*ec2.Image, error) {
-	// Build the EC2 client for the configured region.
-	ec2Client := ec2.New(awsSession, &aws.Config{Region: aws.String(cfg.AWSRegion)})
+// legacyFuzzyImageOwners are the kope.io, Red Hat, CoreOS, and Amazon Linux 2 accounts searched by the legacy fuzzy match mode.
+var legacyFuzzyImageOwners = []string{"383156758163", "309956199498", "595879546273", "137112412989"}
 
-	// Assemble the trusted owner list.
-	kopeioOwner := wellKnownAccountKopeio
-	redHatOwner := wellKnownAccountRedhat
-	coreOSOwner := wellKnownAccountCoreOS
-	awsLinux2Owner := wellKnownAccountAmazonLinux2
+// imageLookup is a targeted DescribeImages query for one image reference.
+type imageLookup struct {
+	// Reference is the image reference the lookup satisfies.
+	Reference *imageReference
+	// Region is the region to query.
+	Region string
+	// Owner is the account ID or owner alias to filter on.
+	Owner string
+	// Name is the AMI name filter, which may contain wildcards.
+	Name string
+	// ImageID is the AMI ID to filter on.
+	ImageID string
+	// Fuzzy searches the legacy owners and leaves name and location matching to the matcher.
+	Fuzzy bool
+}
+
+// key identifies the lookup for de-duplication.
+func (l imageLookup) key() string {
+	return l.Region + "|" + l.Owner + "|" + l.Name + "|" + l.ImageID + "|" + strconv.FormatBool(l.Fuzzy)
+}
+
+// String describes the lookup for log and error messages.
+func (l imageLookup) String() string {
+	// Describe ID lookups by the AMI ID alone.
+	if len(l.ImageID) != 0 {
+		return fmt.Sprintf("image-id %s in %s", l.ImageID, l.Region)
+	}
+
+	return fmt.Sprintf("owner %s name %s in %s", l.owner(), l.Name, l.Region)
+}
+
+// owner describes the owners the lookup searches.
+func (l imageLookup) owner() string {
+	// Fuzzy lookups ignore the reference owner.
+	if l.Fuzzy {
+		return "legacy fuzzy owners " + strings.Join(legacyFuzzyImageOwners, ", ")
+	}
+
+	return l.Owner
+}
+
+// nameFilter returns the DescribeImages name filter finding the images the lookup's name can match.
+func (l imageLookup) nameFilter() string {
+	// Fuzzy names match any image name containing them.
+	if l.Fuzzy {
+		return "*" + l.Name + "*"
+	}
+
+	return l.Name
+}
+
+// conclusive reports whether the first matching image found by the lookup cannot be improved on by later pages.
+func (l imageLookup) conclusive(mode string) bool {
+	// AMI IDs are unique and fuzzy matching accepts the first match.
+	if len(l.ImageID) != 0 || mode == imageMatchModeFuzzy {
+		return true
+	}
+
+	// Exact names are unique per owner account, but wildcards and marketplace aliases need every page to find the newest.
+	return !strings.ContainsAny(l.Name, "*?") && l.Owner != ownerAliasMarketplace
+}
+
+// input builds the DescribeImages request for the lookup.
+func (l imageLookup) input() (*ec2.DescribeImagesInput, error) {
+	// Image IDs are filtered rather than passed as ImageIds so unknown IDs are not an API error.
+	input := &ec2.DescribeImagesInput{MaxResults: aws.Int64(describeImagesPageSize)}
+	// Deprecated images stay launchable by ID, so ID lookups include them as kops does and the deprecation check can report them.
+	if len(l.ImageID) != 0 {
+		input.Filters = append(input.Filters, newEC2Filter("image-id", l.ImageID))
+		input.IncludeDeprecated = aws.Bool(true)
+		return input, nil
+	}
+
+	// Fuzzy lookups list the legacy owners' images, since the matcher also accepts names found only in the image location.
+	if l.Fuzzy {
+		input.Owners = aws.StringSlice(legacyFuzzyImageOwners)
+		return input, nil
+	}
+	input.Filters = append(input.Filters, newEC2Filter("name", l.Name))
+
+	// The calling account has no filter and must be passed as an owner.
+	if l.Owner == selfOwner {
+		input.Owners = aws.StringSlice([]string{selfOwner})
+		return input, nil
+	}
+
+	// Filter account IDs by owner-id and everything else by owner-alias.
+	matched, err := regexp.MatchString(awsAccountIDPattern, l.Owner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse image owner: %w", err)
+	}
+	if matched {
+		input.Filters = append(input.Filters, newEC2Filter("owner-id", l.Owner))
+		return input, nil
+	}
+	input.Filters = append(input.Filters, newEC2Filter("owner-alias", l.Owner))
+
+	return input, nil
+}
+
+// newEC2Filter builds a single value DescribeImages filter.
+func newEC2Filter(name string, value string) *ec2.Filter {
+	return &ec2.Filter{
+		Name:   aws.String(name),
+		Values: aws.StringSlice([]string{value}),
+	}
+}
+
+// imageLookupForReference builds the lookup that can satisfy a reference in a region with the given match mode.
+func imageLookupForReference(ref *imageReference, region string, mode string) imageLookup {
+	// ID and resolved SSM references are looked up by AMI ID.
+	if len(ref.ImageID) != 0 {
+		return imageLookup{Reference: ref, Region: region, ImageID: ref.ImageID}
+	}
+
+	// Legacy fuzzy matching searches the legacy owners for any name or location containing the reference name.
+	if mode == imageMatchModeFuzzy {
+		return imageLookup{Reference: ref, Region: region, Name: strings.TrimSpace(ref.Name), Fuzzy: true}
+	}
+
+	return imageLookup{Reference: ref, Region: region, Owner: ref.Owner, Name: ref.Name}
+}
+
+// listEC2Images queries EC2 for the AMIs each instance group references, keyed by lookup.
+func listEC2Images(ctx context.Context, cfg *CheckConfig, clients *awsClients, groupImages []*instanceGroupImage) (map[string][]*ec2.Image, error) {
+	// De-duplicate the lookups needed by the instance groups.
+	lookups := make(map[string]imageLookup)
+	for _, groupImage := range groupImages {
+		if groupImage == nil || groupImage.Reference == nil {
+			continue
+		}
+		lookup := imageLookupForReference(groupImage.Reference, groupImage.Region, cfg.ImageMatchMode)
+		lookups[lookup.key()] = lookup
+	}
+
+	return describeImageLookups(ctx, clients, lookups, newImageMatcher(cfg), cfg.ImageLookupConcurrency)
+}
+
+// describeImageLookups runs lookups on a bounded worker pool and returns the candidate images found by each lookup, keyed by lookup.
+func describeImageLookups(ctx context.Context, clients *awsClients, lookups map[string]imageLookup, matcher *imageMatcher, concurrency int) (map[string][]*ec2.Image, error) {
+	// Sort the lookups so runs are repeatable.
+	keys := make([]string, 0, len(lookups))
+	for key := range lookups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	log.Infoln("Querying EC2 with", len(keys), "image lookups.")
+
+	// Bound the worker count.
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > len(keys) {
+		concurrency = len(keys)
+	}
+
+	// Start the workers.
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	jobs := make(chan imageLookup)
+	results := make(map[string][]*ec2.Image)
+	errorMessages := make([]string, 0)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for lookup := range jobs {
+				images, err := describeImageLookup(ctx, clients.ec2Client(lookup.Region), lookup, matcher)
 
-	owners := []*string{
-		&kopeioOwner,
-		&redHatOwner,
-		&coreOSOwner,
-		&awsLinux2Owner,
+				// Record results under the lock, keeping each lookup's candidates apart so references only match images their own query returned.
+				lock.Lock()
+				if err != nil {
+					errorMessages = append(errorMessages, err.Error())
+				}
+				results[lookup.key()] = images
+				lock.Unlock()
+			}
+		}()
 	}
 
-	// Request AMIs from the trusted owners.
-	result, err := ec2Client.DescribeImages(&ec2.DescribeImagesInput{
-		Owners: owners,
+	// Feed the lookups and wait for the workers.
+	for _, key := range keys {
+		jobs <- lookups[key]
+	}
+	close(jobs)
+	wg.Wait()
+
+	// Fail when any lookup failed.
+	if len(errorMessages) != 0 {
+		sort.Strings(errorMessages)
+		return nil, fmt.Errorf("failed to list EC2 images: %s", strings.Join(errorMessages, "; "))
+	}
+
+	return results, nil
+}
+
+// describeImageLookup pages through a targeted DescribeImages query, keeping only the candidates the matcher would select.
+func describeImageLookup(ctx context.Context, ec2Client ec2iface.EC2API, lookup imageLookup, matcher *imageMatcher) ([]*ec2.Image, error) {
+	// Build the request.
+	input, err := lookup.input()
+	if err != nil {
+		return nil, err
+	}
+
+	// Evaluate each page as it arrives, stopping once the reference is conclusively satisfied.
+	log.Debugln("Describing images for lookup:", lookup.String())
+	candidates := make([]*ec2.Image, 0)
+	pages := 0
+	err = ec2Client.DescribeImagesPagesWithContext(ctx, input, func(page *ec2.DescribeImagesOutput, lastPage bool) bool {
+		pages++
+		candidates = imageCandidates(lookup.Reference, append(candidates, page.Images...), matcher)
+		if matcher.match(lookup.Reference, candidates) != nil && lookup.conclusive(matcher.Mode) {
+			log.Debugln("Lookup", lookup.String(), "satisfied after", pages, "pages.")
+			return false
+		}
+		return true
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to list EC2 images: %w", err)
+		return nil, fmt.Errorf("lookup %s: %w", lookup.String(), err)
+	}
+
+	return candidates, nil
+}
+
+// imageCandidates keeps the launchable and unavailable images the matcher would select for a reference.
+func imageCandidates(ref *imageReference, images []*ec2.Image, matcher *imageMatcher) []*ec2.Image {
+	// Keep every image when there is no reference to evaluate.
+	if ref == nil {
+		return images
+	}
+
+	// Keep the selected image and, when it differs, the unavailable image reported in its absence.
+	candidates := make([]*ec2.Image, 0, 2)
+	selected := matcher.match(ref, images)
+	if selected != nil {
+		candidates = append(candidates, selected)
+	}
+	unavailable := matcher.matchUnavailable(ref, images)
+	if unavailable != nil && unavailable != selected {
+		candidates = append(candidates, unavailable)
 	}
 
-	return result.Images, nil
+	return candidates
 }
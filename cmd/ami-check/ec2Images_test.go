@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// buildFakeClients returns clients that hand out the given fakes in every region.
+func buildFakeClients(ec2Client ec2iface.EC2API, ssmClient ssmiface.SSMAPI) *awsClients {
+	return &awsClients{
+		newEC2: func(region string) ec2iface.EC2API {
+			return ec2Client
+		},
+		newSSM: func(region string) ssmiface.SSMAPI {
+			return ssmClient
+		},
+	}
+}
+
+// fakeEC2 serves DescribeImages from an in-memory image list.
+type fakeEC2 struct {
+	ec2iface.EC2API
+	lock   sync.Mutex
+	images []*ec2.Image
+	calls  int
+	pages  int
+	// pageSize overrides MaxResults to simulate smaller pages.
+	pageSize int
+	// recycled lists the images in the Recycle Bin.
+	recycled []*ec2.ImageRecycleBinInfo
+}
+
+// DescribeImagesWithContext applies the supported filters to the in-memory images.
+func (f *fakeEC2) DescribeImagesWithContext(ctx context.Context, input *ec2.DescribeImagesInput, opts ...request.Option) (*ec2.DescribeImagesOutput, error) {
+	// Count the call.
+	f.lock.Lock()
+	f.calls++
+	f.lock.Unlock()
+
+	// Keep the images matching every filter.
+	results := make([]*ec2.Image, 0)
+	for _, image := range f.images {
+		if fakeImageMatchesInput(image, input) {
+			results = append(results, image)
+		}
+	}
+
+	// Unknown AMI IDs are an API error.
+	if len(input.ImageIds) != 0 && len(results) == 0 {
+		return nil, awserr.New("InvalidAMIID.NotFound", "image does not exist", nil)
+	}
+
+	return &ec2.DescribeImagesOutput{Images: results}, nil
+}
+
+// ListImagesInRecycleBinPagesWithContext returns the recycled images in one page.
+func (f *fakeEC2) ListImagesInRecycleBinPagesWithContext(ctx context.Context, input *ec2.ListImagesInRecycleBinInput, fn func(*ec2.ListImagesInRecycleBinOutput, bool) bool, opts ...request.Option) error {
+	// Keep the requested IDs.
+	page := &ec2.ListImagesInRecycleBinOutput{}
+	for _, info := range f.recycled {
+		if len(input.ImageIds) == 0 || aws.StringValue(input.ImageIds[0]) == aws.StringValue(info.ImageId) {
+			page.Images = append(page.Images, info)
+		}
+	}
+	fn(page, true)
+
+	return nil
+}
+
+// DescribeImagesPagesWithContext pages through the filtered images MaxResults at a time.
+func (f *fakeEC2) DescribeImagesPagesWithContext(ctx context.Context, input *ec2.DescribeImagesInput, fn func(*ec2.DescribeImagesOutput, bool) bool, opts ...request.Option) error {
+	// Filter the images with a single call.
+	output, err := f.DescribeImagesWithContext(ctx, input)
+	if err != nil {
+		return err
+	}
+
+	// Emit the results one page at a time.
+	pageSize := int(aws.Int64Value(input.MaxResults))
+	if f.pageSize != 0 {
+		pageSize = f.pageSize
+	}
+	if pageSize == 0 {
+		pageSize = len(output.Images) + 1
+	}
+	for start := 0; start == 0 || start < len(output.Images); start += pageSize {
+		end := start + pageSize
+		if end > len(output.Images) {
+			end = len(output.Images)
+		}
+		f.lock.Lock()
+		f.pages++
+		f.lock.Unlock()
+		if !fn(&ec2.DescribeImagesOutput{Images: output.Images[start:end]}, end >= len(output.Images)) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// fakeImageMatchesInput evaluates DescribeImages owners and filters against an image.
+func fakeImageMatchesInput(image *ec2.Image, input *ec2.DescribeImagesInput) bool {
+	// Hide deprecated and disabled images unless requested, as EC2 does.
+	if aws.StringValue(image.State) == ec2.ImageStateDisabled && !aws.BoolValue(input.IncludeDisabled) {
+		return false
+	}
+	if len(aws.StringValue(image.DeprecationTime)) != 0 && !aws.BoolValue(input.IncludeDeprecated) {
+		return false
+	}
+
+	// Apply the AMI IDs.
+	if len(input.ImageIds) != 0 && aws.StringValue(input.ImageIds[0]) != aws.StringValue(image.ImageId) {
+		return false
+	}
+
+	// Apply the owners list.
+	if len(input.Owners) != 0 {
+		owned := false
+		for _, owner := range aws.StringValueSlice(input.Owners) {
+			if owner == aws.StringValue(image.OwnerId) || owner == aws.StringValue(image.ImageOwnerAlias) {
+				owned = true
+			}
+		}
+		if !owned {
+			return false
+		}
+	}
+
+	// Apply each filter.
+	for _, filter := range input.Filters {
+		value := aws.StringValue(filter.Values[0])
+		switch aws.StringValue(filter.Name) {
+		case "name":
+			if !imageNameMatches(aws.StringValue(image.Name), value) {
+				return false
+			}
+		case "image-id":
+			if aws.StringValue(image.ImageId) != value {
+				return false
+			}
+		case "owner-id":
+			if aws.StringValue(image.OwnerId) != value {
+				return false
+			}
+		case "is-public":
+			if fmt.Sprint(aws.BoolValue(image.Public)) != value {
+				return false
+			}
+		case "owner-alias":
+			if aws.StringValue(image.ImageOwnerAlias) != value {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// TestImageLookupInput verifies lookups build targeted filters.
+func TestImageLookupInput(t *testing.T) {
+	// Account owners filter by owner-id.
+	input, err := imageLookup{Owner: "099720109477", Name: "ubuntu-focal"}.input()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(input.Filters) != 2 || aws.StringValue(input.Filters[1].Name) != "owner-id" {
+		t.Fatalf("expected name and owner-id filters, got %v", input.Filters)
+	}
+	if aws.Int64Value(input.MaxResults) != describeImagesPageSize {
+		t.Fatalf("expected paged results, got MaxResults %d", aws.Int64Value(input.MaxResults))
+	}
+
+	// Aliases filter by owner-alias.
+	input, err = imageLookup{Owner: "amazon", Name: "al2023"}.input()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if aws.StringValue(input.Filters[1].Name) != "owner-alias" {
+		t.Fatalf("expected owner-alias filter, got %v", input.Filters)
+	}
+
+	// The calling account is passed as an owner.
+	input, err = imageLookup{Owner: selfOwner, Name: "baked"}.input()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(input.Owners) != 1 || aws.StringValue(input.Owners[0]) != selfOwner {
+		t.Fatalf("expected self owner, got %v", input.Owners)
+	}
+
+	// Image IDs filter by image-id.
+	input, err = imageLookup{ImageID: "ami-0abc123"}.input()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(input.Filters) != 1 || aws.StringValue(input.Filters[0].Name) != "image-id" {
+		t.Fatalf("expected image-id filter, got %v", input.Filters)
+	}
+}
+
+// TestDescribeImageLookupsDeduplicates verifies each distinct lookup is queried once.
+func TestDescribeImageLookupsDeduplicates(t *testing.T) {
+	// Serve two images from different owners.
+	client := &fakeEC2{images: []*ec2.Image{
+		buildOwnedImage("ami-focal", "099720109477", "ubuntu-focal", "2023-01-01T00:00:00.000Z"),
+		buildOwnedImage("ami-flatcar", "075585003325", "flatcar-stable", "2023-01-01T00:00:00.000Z"),
+	}}
+
+	// Build lookups for three groups sharing one image.
+	lookups := make(map[string]imageLookup)
+	focal := imageLookupForReference(&imageReference{Owner: "099720109477", Name: "ubuntu-focal"}, "us-east-1", imageMatchModeExact)
+	refs := []*imageReference{
+		{Owner: "099720109477", Name: "ubuntu-focal"},
+		{Owner: "099720109477", Name: "ubuntu-focal"},
+		{Owner: "075585003325", Name: "flatcar-stable"},
+	}
+	for _, ref := range refs {
+		lookup := imageLookupForReference(ref, "us-east-1", imageMatchModeExact)
+		lookups[lookup.key()] = lookup
+	}
+
+	// Run the lookups.
+	images, err := describeImageLookups(context.Background(), buildFakeClients(client, nil), lookups, &imageMatcher{Mode: imageMatchModeExact, RequireAvailable: true}, 4)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client.calls != 2 {
+		t.Fatalf("expected two DescribeImages calls, got %d", client.calls)
+	}
+	if len(images) != 2 || len(images[focal.key()]) != 1 || aws.StringValue(images[focal.key()][0].ImageId) != "ami-focal" {
+		t.Fatalf("expected each lookup to keep its own image, got %v", images)
+	}
+}
+
+// TestDescribeImageLookupPaging verifies pages are evaluated as they arrive and only candidates are kept.
+func TestDescribeImageLookupPaging(t *testing.T) {
+	// Serve four pages of images owned by one account, the newest still pending.
+	images := make([]*ec2.Image, 0)
+	for day := 10; day <= 28; day++ {
+		created := fmt.Sprintf("2023-01-%02dT00:00:00.000Z", day)
+		images = append(images, buildOwnedImage(fmt.Sprintf("ami-%02d", day), "099720109477", fmt.Sprintf("ubuntu-%02d", day), created))
+	}
+	pending := buildOwnedImage("ami-pending", "099720109477", "ubuntu-pending", "2023-02-01T00:00:00.000Z")
+	pending.State = aws.String(ec2.ImageStatePending)
+	images = append(images, pending)
+	ref := &imageReference{Owner: "099720109477", Name: "ubuntu-*"}
+
+	// A wildcard reads every page and keeps the newest available and newest pending images.
+	client := &fakeEC2{images: images, pageSize: 5}
+	matcher := &imageMatcher{Mode: imageMatchModeExact, RequireAvailable: true}
+	lookup := imageLookupForReference(ref, "us-east-1", matcher.Mode)
+	candidates, err := describeImageLookup(context.Background(), client, lookup, matcher)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client.pages != 4 || len(candidates) != 2 {
+		t.Fatalf("expected four pages and two candidates, got %d pages and %d candidates", client.pages, len(candidates))
+	}
+	if aws.StringValue(candidates[0].ImageId) != "ami-28" || aws.StringValue(candidates[1].ImageId) != "ami-pending" {
+		t.Fatalf("unexpected candidates: %s %s", aws.StringValue(candidates[0].ImageId), aws.StringValue(candidates[1].ImageId))
+	}
+
+	// Fuzzy matching stops after the first page with a match among the legacy owners' images.
+	legacyImages := make([]*ec2.Image, 0, len(images))
+	for _, image := range images {
+		legacyImage := *image
+		legacyImage.OwnerId = aws.String(legacyFuzzyImageOwners[0])
+		legacyImages = append(legacyImages, &legacyImage)
+	}
+	client = &fakeEC2{images: legacyImages, pageSize: 5}
+	matcher = &imageMatcher{Mode: imageMatchModeFuzzy, RequireAvailable: true}
+	lookup = imageLookupForReference(&imageReference{Owner: "099720109477", Name: "ubuntu"}, "us-east-1", matcher.Mode)
+	candidates, err = describeImageLookup(context.Background(), client, lookup, matcher)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client.pages != 1 || len(candidates) != 1 {
+		t.Fatalf("expected to stop after the first page, got %d pages and %d candidates", client.pages, len(candidates))
+	}
+}
+
+// TestSelfReferenceIgnoresOtherLookups verifies a bare name is not satisfied by an image another group's owner lookup found.
+func TestSelfReferenceIgnoresOtherLookups(t *testing.T) {
+	// Only Canonical publishes the name.
+	client := &fakeEC2{images: []*ec2.Image{
+		buildOwnedImage("ami-1", "099720109477", "jammy-foo", "2023-01-01T00:00:00.000Z"),
+	}}
+
+	// Reference the name with and without an owner in the same region.
+	groupImages := make([]*instanceGroupImage, 0)
+	for _, raw := range []string{"ubuntu/jammy-foo", "jammy-foo"} {
+		ref, err := parseImageReference(raw)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		groupImages = append(groupImages, &instanceGroupImage{Group: buildInstanceGroup(raw), Reference: ref, Region: "us-east-1"})
+	}
+
+	// Look up and match the images.
+	cfg := &CheckConfig{ImageMatchMode: imageMatchModeExact, RequireAvailableImage: true, ImageLookupConcurrency: 2}
+	images, err := listEC2Images(context.Background(), cfg, buildFakeClients(client, nil), groupImages)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	matches, missing := checkImagesAreAvailable(context.Background(), groupImages, images, newImageMatcher(cfg), nil)
+
+	// Only the owner qualified reference is satisfied.
+	if len(matches) != 1 || matches[0].Reference.Raw != "ubuntu/jammy-foo" {
+		t.Fatalf("expected only ubuntu/jammy-foo to match, got %v", matches)
+	}
+	if len(missing) != 1 || !strings.Contains(missing[0], "jammy-foo") {
+		t.Fatalf("expected the bare name to be missing, got %v", missing)
+	}
+}
+
+// TestFuzzyLookupKeepsLegacyOwners verifies fuzzy mode searches the legacy owners and matches image locations locally.
+func TestFuzzyLookupKeepsLegacyOwners(t *testing.T) {
+	// Offer a kope.io image matching only on location and a Canonical image matching on name.
+	located := buildOwnedImage("ami-kope", "383156758163", "kope-debian", "2023-01-01T00:00:00.000Z")
+	located.ImageLocation = aws.String("383156758163/k8s-1.27-debian-bookworm")
+	client := &fakeEC2{images: []*ec2.Image{
+		located,
+		buildOwnedImage("ami-canonical", "099720109477", "k8s-1.27-ubuntu", "2023-06-01T00:00:00.000Z"),
+	}}
+
+	// A bare name searches the legacy owners rather than the calling account.
+	ref, err := parseImageReference("k8s-1.27")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lookup := imageLookupForReference(ref, "us-east-1", imageMatchModeFuzzy)
+	input, err := lookup.input()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(input.Filters) != 0 || len(input.Owners) != len(legacyFuzzyImageOwners) {
+		t.Fatalf("expected the legacy owners without filters, got %v %v", input.Owners, input.Filters)
+	}
+
+	// The location match from a legacy owner is selected.
+	matcher := &imageMatcher{Mode: imageMatchModeFuzzy, RequireAvailable: true}
+	candidates, err := describeImageLookup(context.Background(), client, lookup, matcher)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(candidates) != 1 || aws.StringValue(candidates[0].ImageId) != "ami-kope" {
+		t.Fatalf("expected ami-kope, got %v", candidates)
+	}
+}
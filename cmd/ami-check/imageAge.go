@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/kops/pkg/apis/kops"
+)
+
+const (
+	// maxImageAgeLabel is the instance group label overriding MAX_IMAGE_AGE; 0 disables the policy for the group.
+	maxImageAgeLabel = "ami-check.kuberhealthy.github.io/max-image-age"
+)
+
+// checkImageAge reports matched images older than their group's maximum age, and groups with an invalid age label.
+func checkImageAge(matches []*imageMatch, maxAge time.Duration, now time.Time) ([]string, []string) {
+	// Prepare the result lists.
+	stale := make([]string, 0)
+	invalid := make([]string, 0)
+	reported := make(map[string]bool)
+
+	// Inspect each matched image.
+	for _, match := range matches {
+		// Resolve the group's maximum age.
+		groupMaxAge, err := instanceGroupMaxImageAge(match.Group, maxAge)
+		if err != nil {
+			if !reported[match.Group.Name] {
+				reported[match.Group.Name] = true
+				invalid = append(invalid, fmt.Sprintf("instance group %s: %s", match.Group.Name, err.Error()))
+			}
+			continue
+		}
+		if groupMaxAge == 0 {
+			continue
+		}
+
+		// Skip images without a parseable creation date.
+		value := aws.StringValue(match.Image.CreationDate)
+		createdAt, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			log.Errorf("failed to parse creation date %q of image %s: %s", value, aws.StringValue(match.Image.ImageId), err.Error())
+			continue
+		}
+
+		// Flag images older than the maximum.
+		age := now.Sub(createdAt)
+		if age > groupMaxAge {
+			message := fmt.Sprintf("[%s] instance group %s image %s (%s) was created on %s and is %s old, exceeding the maximum age of %s",
+				match.Region, match.Group.Name, match.Reference.String(), aws.StringValue(match.Image.ImageId),
+				createdAt.UTC().Format(time.RFC3339), formatAge(age), formatAge(groupMaxAge))
+			stale = append(stale, message)
+		}
+	}
+
+	return stale, invalid
+}
+
+// instanceGroupMaxImageAge returns the maximum image age of a group, honoring the label override.
+func instanceGroupMaxImageAge(group *kops.InstanceGroup, maxAge time.Duration) (time.Duration, error) {
+	// Use the global maximum without a label.
+	value, ok := group.Labels[maxImageAgeLabel]
+	if !ok {
+		return maxAge, nil
+	}
+
+	return parseDuration("label "+maxImageAgeLabel, value)
+}
+
+// formatAge renders an age in whole days, or as a duration when shorter than a day.
+func formatAge(age time.Duration) string {
+	// Prefer days for readability.
+	if age < time.Hour*24 {
+		return age.Round(time.Second).String()
+	}
+
+	return fmt.Sprintf("%d days", int(age.Hours()/24))
+}
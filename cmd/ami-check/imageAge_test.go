@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCheckImageAge verifies the global maximum, label overrides, and invalid labels.
+func TestCheckImageAge(t *testing.T) {
+	// Build images of 120 and 10 days old.
+	now := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	old := buildOwnedImage("ami-old", "099720109477", "old", "2024-01-02T00:00:00.000Z")
+	recent := buildOwnedImage("ami-recent", "099720109477", "recent", "2024-04-21T00:00:00.000Z")
+
+	// Build groups using the global maximum, a disabled policy, a stricter label, and an invalid label.
+	global := buildInstanceGroup("old")
+	global.Name = "global"
+	exempt := buildInstanceGroup("old")
+	exempt.Name = "exempt"
+	exempt.Labels = map[string]string{maxImageAgeLabel: "0"}
+	strict := buildInstanceGroup("recent")
+	strict.Name = "strict"
+	strict.Labels = map[string]string{maxImageAgeLabel: "7d"}
+	broken := buildInstanceGroup("recent")
+	broken.Name = "broken"
+	broken.Labels = map[string]string{maxImageAgeLabel: "soon"}
+	matches := []*imageMatch{
+		{Group: global, Reference: &imageReference{Raw: "old"}, Image: old, Region: "us-east-1"},
+		{Group: exempt, Reference: &imageReference{Raw: "old"}, Image: old, Region: "us-east-1"},
+		{Group: strict, Reference: &imageReference{Raw: "recent"}, Image: recent, Region: "us-east-1"},
+		{Group: broken, Reference: &imageReference{Raw: "recent"}, Image: recent, Region: "us-east-1"},
+		{Group: broken, Reference: &imageReference{Raw: "recent"}, Image: recent, Region: "us-west-2"},
+	}
+
+	// Check against a 90 day maximum.
+	stale, invalid := checkImageAge(matches, time.Hour*24*90, now)
+	if len(stale) != 2 {
+		t.Fatalf("expected two stale images, got %v", stale)
+	}
+	if !strings.Contains(stale[0], "instance group global") || !strings.Contains(stale[0], "is 120 days old, exceeding the maximum age of 90 days") {
+		t.Fatalf("unexpected global finding: %s", stale[0])
+	}
+	if !strings.Contains(stale[1], "instance group strict") || !strings.Contains(stale[1], "is 10 days old, exceeding the maximum age of 7 days") {
+		t.Fatalf("unexpected strict finding: %s", stale[1])
+	}
+	if len(invalid) != 1 || !strings.Contains(invalid[0], "instance group broken") {
+		t.Fatalf("expected one invalid label, got %v", invalid)
+	}
+
+	// A disabled global policy only applies labels.
+	stale, _ = checkImageAge(matches, 0, now)
+	if len(stale) != 1 || !strings.Contains(stale[0], "instance group strict") {
+		t.Fatalf("expected only the labeled group, got %v", stale)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	log "github.com/sirupsen/logrus"
+)
+
+// checkImageDeprecation reports matched images that are already deprecated and those deprecated within the window.
+func checkImageDeprecation(matches []*imageMatch, window time.Duration, now time.Time) ([]string, []string) {
+	// Prepare the result lists.
+	deprecated := make([]string, 0)
+	upcoming := make([]string, 0)
+
+	// Inspect each matched image.
+	for _, match := range matches {
+		// Skip images without a deprecation time.
+		value := aws.StringValue(match.Image.DeprecationTime)
+		if len(value) == 0 {
+			continue
+		}
+		deprecationTime, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			log.Errorf("failed to parse deprecation time %s of image %s: %s", value, aws.StringValue(match.Image.ImageId), err.Error())
+			continue
+		}
+
+		// Fail images that are already deprecated.
+		date := deprecationTime.UTC().Format(time.RFC3339)
+		if !deprecationTime.After(now) {
+			message := fmt.Sprintf("[%s] instance group %s image %s (%s) was deprecated on %s",
+				match.Region, match.Group.Name, aws.StringValue(match.Image.ImageId), aws.StringValue(match.Image.Name), date)
+			deprecated = append(deprecated, message)
+			continue
+		}
+
+		// Flag images deprecated within the lookahead window.
+		remaining := deprecationTime.Sub(now)
+		if remaining <= window {
+			message := fmt.Sprintf("[%s] instance group %s image %s (%s) will be deprecated on %s, in %d days",
+				match.Region, match.Group.Name, aws.StringValue(match.Image.ImageId), aws.StringValue(match.Image.Name), date, int(remaining.Hours()/24))
+			upcoming = append(upcoming, message)
+			continue
+		}
+
+		log.Debugf("Image %s is deprecated on %s, outside the lookahead window.", aws.StringValue(match.Image.ImageId), date)
+	}
+
+	return deprecated, upcoming
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// TestCheckImageDeprecation verifies deprecated and soon deprecated images are classified.
+func TestCheckImageDeprecation(t *testing.T) {
+	// Build images deprecated in the past, soon, later, and never.
+	now := time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)
+	past := buildOwnedImage("ami-past", "099720109477", "past", "2023-01-01T00:00:00.000Z")
+	past.DeprecationTime = aws.String("2024-05-01T00:00:00.000Z")
+	soon := buildOwnedImage("ami-soon", "099720109477", "soon", "2023-01-01T00:00:00.000Z")
+	soon.DeprecationTime = aws.String("2024-06-15T00:00:00.000Z")
+	later := buildOwnedImage("ami-later", "099720109477", "later", "2023-01-01T00:00:00.000Z")
+	later.DeprecationTime = aws.String("2025-01-01T00:00:00.000Z")
+	never := buildOwnedImage("ami-never", "099720109477", "never", "2023-01-01T00:00:00.000Z")
+	matches := make([]*imageMatch, 0)
+	for _, image := range []*ec2.Image{past, soon, later, never} {
+		matches = append(matches, &imageMatch{Group: buildInstanceGroup("image"), Reference: &imageReference{}, Image: image, Region: "us-east-1"})
+	}
+
+	// Check with a 30 day window.
+	deprecated, upcoming := checkImageDeprecation(matches, time.Hour*24*30, now)
+	if len(deprecated) != 1 || !strings.Contains(deprecated[0], "ami-past") {
+		t.Fatalf("expected ami-past to be deprecated, got %v", deprecated)
+	}
+	if len(upcoming) != 1 || !strings.Contains(upcoming[0], "2024-06-15T00:00:00Z") {
+		t.Fatalf("expected ami-soon with its date to be upcoming, got %v", upcoming)
+	}
+}
+
+// TestDeprecatedImageByID verifies an AMI ID reference to a deprecated image reports the deprecation rather than a missing image.
+func TestDeprecatedImageByID(t *testing.T) {
+	// Serve a deprecated image, which EC2 hides unless deprecated images are requested.
+	now := time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)
+	image := buildOwnedImage("ami-0deprecated", "099720109477", "old", "2023-01-01T00:00:00.000Z")
+	image.DeprecationTime = aws.String("2024-05-01T00:00:00.000Z")
+	client := &fakeEC2{images: []*ec2.Image{image}}
+	ref, err := parseImageReference("ami-0deprecated")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	groupImages := []*instanceGroupImage{{Group: buildInstanceGroup("ami-0deprecated"), Reference: ref, Region: "us-east-1"}}
+
+	// Look up and match the image.
+	cfg := &CheckConfig{ImageMatchMode: imageMatchModeExact, RequireAvailableImage: true, ImageLookupConcurrency: 1}
+	images, err := listEC2Images(context.Background(), cfg, buildFakeClients(client, nil), groupImages)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	matches, missing := checkImagesAreAvailable(context.Background(), groupImages, images, newImageMatcher(cfg), nil)
+	if len(missing) != 0 {
+		t.Fatalf("expected the deprecated image to be found, got %v", missing)
+	}
+
+	// The deprecation fails the check.
+	deprecated, _ := checkImageDeprecation(matches, time.Hour*24*30, now)
+	if len(deprecated) != 1 || !strings.Contains(deprecated[0], "ami-0deprecated") {
+		t.Fatalf("expected a deprecation failure, got %v", deprecated)
+	}
+}
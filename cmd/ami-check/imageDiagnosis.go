@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxDiagnosisOwners caps how many other owners of a missing image name are listed.
+	maxDiagnosisOwners = 5
+)
+
+// imageDiagnoser explains why an image reference could not be satisfied, caching each diagnosis.
+type imageDiagnoser struct {
+	// clients builds the region scoped EC2 clients.
+	clients *awsClients
+	// mode is the exact or fuzzy match mode.
+	mode string
+	// reasons caches diagnoses by lookup key.
+	reasons map[string]string
+}
+
+// newImageDiagnoser builds a diagnoser for the match mode.
+func newImageDiagnoser(clients *awsClients, mode string) *imageDiagnoser {
+	return &imageDiagnoser{
+		clients: clients,
+		mode:    mode,
+		reasons: make(map[string]string),
+	}
+}
+
+// diagnose returns why a group's image could not be found, or an empty string when no reason can be determined.
+func (d *imageDiagnoser) diagnose(ctx context.Context, groupImage *instanceGroupImage) string {
+	// Serve repeated references from the cache.
+	lookup := imageLookupForReference(groupImage.Reference, groupImage.Region, d.mode)
+	reason, ok := d.reasons[lookup.key()]
+	if ok {
+		return reason
+	}
+
+	// Diagnosis is best effort, so failures leave the plain message.
+	reason, err := diagnoseMissingImage(ctx, d.clients.ec2Client(groupImage.Region), lookup, d.mode)
+	if err != nil {
+		log.Warnln("failed to diagnose missing image", lookup.String()+":", err.Error())
+		reason = ""
+	}
+	d.reasons[lookup.key()] = reason
+
+	return reason
+}
+
+// diagnoseMissingImage classifies a missing image as disabled, hidden deprecated, deregistered, owned elsewhere, or never existing.
+func diagnoseMissingImage(ctx context.Context, ec2Client ec2iface.EC2API, lookup imageLookup, mode string) (string, error) {
+	// Look for deprecated and disabled images, which default lookups hide.
+	hidden, err := describeHiddenImage(ctx, ec2Client, lookup, mode)
+	if err != nil {
+		return "", err
+	}
+	if hidden != nil {
+		return hiddenImageReason(hidden), nil
+	}
+
+	// Look up AMI IDs directly, which also returns recently deregistered images.
+	if len(lookup.ImageID) != 0 {
+		reason, err := describeImageByID(ctx, ec2Client, lookup.ImageID)
+		if err != nil || len(reason) != 0 {
+			return reason, err
+		}
+	}
+
+	// Look for images of the calling account waiting in the Recycle Bin.
+	reason, err := describeRecycledImage(ctx, ec2Client, lookup, mode)
+	if err != nil || len(reason) != 0 {
+		return reason, err
+	}
+
+	// Look for the name under other owners.
+	if len(lookup.ImageID) == 0 {
+		owners, err := describeOtherImageOwners(ctx, ec2Client, lookup)
+		if err != nil {
+			return "", err
+		}
+		if len(owners) != 0 {
+			return fmt.Sprintf("no image with that name is owned by %s, but it is published by %s", lookup.owner(), strings.Join(owners, ", ")), nil
+		}
+	}
+
+	return "no image with that ID or name is visible in the region; it never existed there or was deregistered by its owner", nil
+}
+
+// describeHiddenImage finds a deprecated or disabled image satisfying the lookup's reference, or nil.
+func describeHiddenImage(ctx context.Context, ec2Client ec2iface.EC2API, lookup imageLookup, mode string) (*ec2.Image, error) {
+	// Repeat the lookup including hidden images.
+	input, err := lookup.input()
+	if err != nil {
+		return nil, err
+	}
+	input.IncludeDeprecated = aws.Bool(true)
+	input.IncludeDisabled = aws.Bool(true)
+
+	// Keep the image the reference would select, ignoring its state.
+	var selected *ec2.Image
+	err = ec2Client.DescribeImagesPagesWithContext(ctx, input, func(page *ec2.DescribeImagesOutput, lastPage bool) bool {
+		candidates := page.Images
+		if selected != nil {
+			candidates = append([]*ec2.Image{selected}, page.Images...)
+		}
+		selected = matchImage(lookup.Reference, candidates, mode)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe hidden images: %w", err)
+	}
+
+	return selected, nil
+}
+
+// hiddenImageReason explains why a deprecated, disabled, or unavailable image was not selected.
+func hiddenImageReason(image *ec2.Image) string {
+	// Disabled images cannot be launched until re-enabled.
+	imageID := aws.StringValue(image.ImageId)
+	state := aws.StringValue(image.State)
+	if state == ec2.ImageStateDisabled {
+		return fmt.Sprintf("image %s is disabled and hidden from default lookups", imageID)
+	}
+	if state == ec2.ImageStateDeregistered {
+		return fmt.Sprintf("image %s was deregistered", imageID)
+	}
+
+	// Deprecated images stay launchable by ID but are hidden from name lookups.
+	deprecationTime, err := time.Parse(time.RFC3339, aws.StringValue(image.DeprecationTime))
+	if err == nil && !deprecationTime.After(time.Now()) {
+		return fmt.Sprintf("image %s was deprecated on %s and is hidden from default lookups, so kops cannot resolve it by name",
+			imageID, deprecationTime.UTC().Format(time.RFC3339))
+	}
+
+	return fmt.Sprintf("image %s is only visible when including deprecated or disabled images (state %s)", imageID, state)
+}
+
+// describeImageByID classifies an AMI ID that no lookup returned, returning an empty string when EC2 has no record of it.
+func describeImageByID(ctx context.Context, ec2Client ec2iface.EC2API, imageID string) (string, error) {
+	// Query the ID directly, which reports unknown and malformed IDs as errors.
+	output, err := ec2Client.DescribeImagesWithContext(ctx, &ec2.DescribeImagesInput{
+		ImageIds:          aws.StringSlice([]string{imageID}),
+		IncludeDeprecated: aws.Bool(true),
+		IncludeDisabled:   aws.Bool(true),
+	})
+	switch {
+	case awsErrorCode(err) == "InvalidAMIID.Malformed":
+		return fmt.Sprintf("%s is not a valid AMI ID", imageID), nil
+	case awsErrorCode(err) == "InvalidAMIID.NotFound" || awsErrorCode(err) == "InvalidAMIID.Unavailable":
+		return "", nil
+	case err != nil:
+		return "", fmt.Errorf("failed to describe image %s: %w", imageID, err)
+	}
+
+	// Report whatever state the image is in.
+	for _, image := range output.Images {
+		if image != nil && aws.StringValue(image.ImageId) == imageID {
+			return hiddenImageReason(image), nil
+		}
+	}
+
+	return "", nil
+}
+
+// describeRecycledImage finds a deregistered image of the calling account in the Recycle Bin, returning an empty string when there is none.
+func describeRecycledImage(ctx context.Context, ec2Client ec2iface.EC2API, lookup imageLookup, mode string) (string, error) {
+	// Scope ID lookups to the image.
+	input := &ec2.ListImagesInRecycleBinInput{}
+	if len(lookup.ImageID) != 0 {
+		input.ImageIds = aws.StringSlice([]string{lookup.ImageID})
+	}
+
+	// Find a recycled image the reference would have selected.
+	var recycled *ec2.ImageRecycleBinInfo
+	err := ec2Client.ListImagesInRecycleBinPagesWithContext(ctx, input, func(page *ec2.ListImagesInRecycleBinOutput, lastPage bool) bool {
+		for _, info := range page.Images {
+			if info == nil {
+				continue
+			}
+			if recycledImageMatches(info, lookup, mode) {
+				recycled = info
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		// The Recycle Bin permission is optional.
+		code := awsErrorCode(err)
+		if code == "UnauthorizedOperation" || code == "AccessDenied" || code == "InvalidAMIID.NotFound" {
+			log.Debugln("Skipping Recycle Bin lookup for", lookup.String()+":", err.Error())
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to list images in the Recycle Bin: %w", err)
+	}
+	if recycled == nil {
+		return "", nil
+	}
+
+	return fmt.Sprintf("image %s (%s) was deregistered on %s and can be restored from the Recycle Bin until %s",
+		aws.StringValue(recycled.ImageId), aws.StringValue(recycled.Name),
+		aws.TimeValue(recycled.RecycleBinEnterTime).UTC().Format(time.RFC3339), aws.TimeValue(recycled.RecycleBinExitTime).UTC().Format(time.RFC3339)), nil
+}
+
+// recycledImageMatches checks a Recycle Bin entry against a lookup by ID or name.
+func recycledImageMatches(info *ec2.ImageRecycleBinInfo, lookup imageLookup, mode string) bool {
+	// Match IDs exactly.
+	if len(lookup.ImageID) != 0 {
+		return aws.StringValue(info.ImageId) == lookup.ImageID
+	}
+
+	// Only the calling account's images are recycled, so match the name alone.
+	if mode == imageMatchModeFuzzy {
+		return imageMatchesInstanceGroup(&ec2.Image{Name: info.Name}, lookup.Reference.Name)
+	}
+
+	return imageNameMatches(aws.StringValue(info.Name), lookup.Name)
+}
+
+// describeOtherImageOwners lists the owners publishing the lookup's name, up to maxDiagnosisOwners.
+func describeOtherImageOwners(ctx context.Context, ec2Client ec2iface.EC2API, lookup imageLookup) ([]string, error) {
+	// Search the name under every owner.
+	input := &ec2.DescribeImagesInput{
+		Filters:           []*ec2.Filter{newEC2Filter("name", lookup.nameFilter())},
+		IncludeDeprecated: aws.Bool(true),
+		MaxResults:        aws.Int64(describeImagesPageSize),
+	}
+
+	// Collect distinct owners, stopping once enough are known.
+	seen := make(map[string]bool)
+	err := ec2Client.DescribeImagesPagesWithContext(ctx, input, func(page *ec2.DescribeImagesOutput, lastPage bool) bool {
+		for _, image := range page.Images {
+			if image == nil {
+				continue
+			}
+			owner := aws.StringValue(image.OwnerId)
+			if len(aws.StringValue(image.ImageOwnerAlias)) != 0 {
+				owner = fmt.Sprintf("%s (%s)", owner, aws.StringValue(image.ImageOwnerAlias))
+			}
+			seen[owner] = true
+		}
+		return len(seen) < maxDiagnosisOwners
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe images by name: %w", err)
+	}
+
+	// Return the owners in a stable order.
+	owners := make([]string, 0, len(seen))
+	for owner := range seen {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+
+	return owners, nil
+}
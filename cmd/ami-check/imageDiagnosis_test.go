@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// TestDiagnoseMissingImage verifies each reason a reference can go unsatisfied is told apart.
+func TestDiagnoseMissingImage(t *testing.T) {
+	// Serve a deprecated image, a disabled image, and an image under another owner.
+	deprecated := buildOwnedImage("ami-deprecated", "099720109477", "ubuntu-old", "2020-01-01T00:00:00.000Z")
+	deprecated.DeprecationTime = aws.String("2022-01-01T00:00:00.000Z")
+	disabled := buildOwnedImage("ami-disabled", "099720109477", "ubuntu-disabled", "2020-01-01T00:00:00.000Z")
+	disabled.State = aws.String(ec2.ImageStateDisabled)
+	foreign := buildOwnedImage("ami-foreign", "111111111111", "ubuntu-focal", "2020-01-01T00:00:00.000Z")
+	client := &fakeEC2{
+		images: []*ec2.Image{deprecated, disabled, foreign},
+		recycled: []*ec2.ImageRecycleBinInfo{{
+			ImageId:             aws.String("ami-recycled"),
+			Name:                aws.String("baked"),
+			RecycleBinEnterTime: aws.Time(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+			RecycleBinExitTime:  aws.Time(time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)),
+		}},
+	}
+
+	// Diagnose each reference.
+	cases := map[*imageReference]string{
+		{Owner: "099720109477", Name: "ubuntu-old"}:      "image ami-deprecated was deprecated on 2022-01-01T00:00:00Z and is hidden from default lookups",
+		{Owner: "099720109477", Name: "ubuntu-disabled"}: "image ami-disabled is disabled",
+		{ImageID: "ami-recycled"}:                        "can be restored from the Recycle Bin until 2024-01-08T00:00:00Z",
+		{Owner: "099720109477", Name: "ubuntu-focal"}:    "no image with that name is owned by 099720109477, but it is published by 111111111111",
+		{ImageID: "ami-0gone"}:                           "it never existed there or was deregistered by its owner",
+	}
+	for ref, expected := range cases {
+		lookup := imageLookupForReference(ref, "us-east-1", imageMatchModeExact)
+		reason, err := diagnoseMissingImage(context.Background(), client, lookup, imageMatchModeExact)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", lookup.String(), err)
+		}
+		if !strings.Contains(reason, expected) {
+			t.Fatalf("expected %s to be diagnosed with %q, got %q", lookup.String(), expected, reason)
+		}
+	}
+}
+
+// TestCheckImagesAreAvailableDiagnoses verifies missing image messages carry the diagnosis.
+func TestCheckImagesAreAvailableDiagnoses(t *testing.T) {
+	// Serve only a deprecated image, which default lookups hide.
+	deprecated := buildOwnedImage("ami-deprecated", "099720109477", "ubuntu-old", "2020-01-01T00:00:00.000Z")
+	deprecated.DeprecationTime = aws.String("2022-01-01T00:00:00.000Z")
+	clients := buildFakeClients(&fakeEC2{images: []*ec2.Image{deprecated}}, nil)
+	groupImage := &instanceGroupImage{
+		Group:     buildInstanceGroup("099720109477/ubuntu-old"),
+		Reference: &imageReference{Raw: "099720109477/ubuntu-old", Kind: imageReferenceOwnerAccount, Owner: "099720109477", Name: "ubuntu-old"},
+		Region:    "us-east-1",
+	}
+
+	// Check availability with the diagnoser.
+	matcher := &imageMatcher{Mode: imageMatchModeExact, RequireAvailable: true}
+	_, errorMessages := checkImagesAreAvailable(context.Background(), []*instanceGroupImage{groupImage}, nil, matcher, newImageDiagnoser(clients, imageMatchModeExact))
+	if len(errorMessages) != 1 || !strings.Contains(errorMessages[0], "for instance group ig-name: image ami-deprecated was deprecated") {
+		t.Fatalf("expected a diagnosed message, got %v", errorMessages)
+	}
+}
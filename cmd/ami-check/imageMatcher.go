@@ -0,0 +1,212 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/kops/pkg/apis/kops"
+)
+
+const (
+	// imageMatchModeExact compares owner and exact name or AMI ID like kops does.
+	imageMatchModeExact = "exact"
+	// imageMatchModeFuzzy keeps the legacy substring matching on name and location.
+	imageMatchModeFuzzy = "fuzzy"
+)
+
+// imageMatch records the EC2 image that satisfied an instance group.
+type imageMatch struct {
+	// Group is the kops instance group.
+	Group *kops.InstanceGroup
+	// Reference is the image reference of the instance group.
+	Reference *imageReference
+	// Image is the EC2 image that satisfied the reference.
+	Image *ec2.Image
+	// Region is the region the image was found in.
+	Region string
+}
+
+// imageMatcher selects the EC2 image satisfying a reference under the configured policy.
+type imageMatcher struct {
+	// Mode is the exact or fuzzy match mode.
+	Mode string
+	// RequireAvailable only accepts images in the available state.
+	RequireAvailable bool
+}
+
+// newImageMatcher builds the matcher from the check configuration.
+func newImageMatcher(cfg *CheckConfig) *imageMatcher {
+	return &imageMatcher{
+		Mode:             cfg.ImageMatchMode,
+		RequireAvailable: cfg.RequireAvailableImage,
+	}
+}
+
+// match returns the image satisfying the reference, or nil when none does.
+func (m *imageMatcher) match(ref *imageReference, images []*ec2.Image) *ec2.Image {
+	// Consider every image when the state is not enforced.
+	if !m.RequireAvailable {
+		return matchImage(ref, images, m.Mode)
+	}
+
+	// Only consider launchable images.
+	available := make([]*ec2.Image, 0, len(images))
+	for _, image := range images {
+		if image != nil && aws.StringValue(image.State) == ec2.ImageStateAvailable {
+			available = append(available, image)
+		}
+	}
+
+	return matchImage(ref, available, m.Mode)
+}
+
+// matchUnavailable returns an image that satisfies the reference but is not launchable, or nil.
+func (m *imageMatcher) matchUnavailable(ref *imageReference, images []*ec2.Image) *ec2.Image {
+	// Unavailable images are only rejected when the state is enforced.
+	if !m.RequireAvailable {
+		return nil
+	}
+
+	return matchImage(ref, images, m.Mode)
+}
+
+// matchImage selects the image that satisfies a reference using the configured match mode.
+func matchImage(ref *imageReference, images []*ec2.Image, mode string) *ec2.Image {
+	// Legacy mode accepts the first loosely matching image.
+	if mode == imageMatchModeFuzzy {
+		for _, image := range images {
+			if imageMatchesReferenceFuzzy(image, ref) {
+				return image
+			}
+		}
+		return nil
+	}
+
+	// Exact mode picks the newest matching image, as kops does for wildcard names.
+	var selected *ec2.Image
+	for _, image := range images {
+		if !imageMatchesReference(image, ref) {
+			continue
+		}
+		if selected == nil || imageCreatedAfter(image, selected) {
+			selected = image
+		}
+	}
+
+	return selected
+}
+
+// imageMatchesReference checks whether an EC2 image satisfies a reference by AMI ID or owner and exact name.
+func imageMatchesReference(image *ec2.Image, ref *imageReference) bool {
+	// Guard against nil inputs.
+	if image == nil || ref == nil {
+		return false
+	}
+
+	// ID and resolved SSM references match on the AMI ID.
+	if len(ref.ImageID) != 0 {
+		return aws.StringValue(image.ImageId) == ref.ImageID
+	}
+
+	// Name references must match the owner and the exact name.
+	if !imageMatchesOwner(image, ref.Owner) {
+		return false
+	}
+
+	return imageNameMatches(aws.StringValue(image.Name), ref.Name)
+}
+
+// imageMatchesOwner checks the image owner against an account ID or EC2 owner alias.
+func imageMatchesOwner(image *ec2.Image, owner string) bool {
+	// The calling account is only known to EC2, which scoped the reference's own lookup to it, so only images from that lookup may be compared.
+	if owner == selfOwner {
+		return true
+	}
+
+	// Compare account IDs and owner aliases.
+	if aws.StringValue(image.OwnerId) == owner {
+		return true
+	}
+
+	return aws.StringValue(image.ImageOwnerAlias) == owner
+}
+
+// imageNameMatches compares an AMI name to a reference name, honoring EC2 filter wildcards.
+func imageNameMatches(imageName string, name string) bool {
+	// Names without wildcards must be identical.
+	if len(imageName) == 0 || len(name) == 0 {
+		return false
+	}
+	if !strings.ContainsAny(name, "*?") {
+		return imageName == name
+	}
+
+	// Translate EC2 wildcards into an anchored expression.
+	pattern := regexp.QuoteMeta(name)
+	pattern = strings.ReplaceAll(pattern, `\*`, `.*`)
+	pattern = strings.ReplaceAll(pattern, `\?`, `.`)
+	matched, err := regexp.MatchString("^"+pattern+"$", imageName)
+	if err != nil {
+		log.Errorln("failed to compile image name pattern:", err.Error())
+		return false
+	}
+
+	return matched
+}
+
+// imageCreatedAfter reports whether image a was created after image b.
+func imageCreatedAfter(a *ec2.Image, b *ec2.Image) bool {
+	// Unparseable dates sort as the zero time, matching kops.
+	aTime, _ := time.Parse(time.RFC3339, aws.StringValue(a.CreationDate))
+	bTime, _ := time.Parse(time.RFC3339, aws.StringValue(b.CreationDate))
+
+	return aTime.After(bTime)
+}
+
+// imageMatchesReferenceFuzzy applies the legacy substring matching to a reference.
+func imageMatchesReferenceFuzzy(image *ec2.Image, ref *imageReference) bool {
+	// Guard against nil inputs.
+	if image == nil || ref == nil {
+		return false
+	}
+
+	// ID and resolved SSM references still match on the AMI ID.
+	if len(ref.ImageID) != 0 {
+		return aws.StringValue(image.ImageId) == ref.ImageID
+	}
+
+	return imageMatchesInstanceGroup(image, ref.Name)
+}
+
+// imageMatchesInstanceGroup checks whether an EC2 image name or location contains the image name.
+func imageMatchesInstanceGroup(image *ec2.Image, imageName string) bool {
+	// Guard against nil inputs.
+	if image == nil {
+		return false
+	}
+	if len(imageName) == 0 {
+		return false
+	}
+
+	// Check the EC2 image name field.
+	if image.Name != nil {
+		if strings.Contains(strings.TrimSpace(*image.Name), strings.TrimSpace(imageName)) {
+			log.Infoln("Found kops instance group image within list:", *image.Name)
+			return true
+		}
+	}
+
+	// Check the EC2 image location field.
+	if image.ImageLocation != nil {
+		if strings.Contains(strings.TrimSpace(*image.ImageLocation), strings.TrimSpace(imageName)) {
+			log.Infoln("Found kops instance group image within list:", *image.ImageLocation)
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// buildOwnedImage constructs an EC2 image with an ID, owner, and creation date.
+func buildOwnedImage(id string, owner string, name string, created string) *ec2.Image {
+	// Fill the fields used by the exact matcher.
+	image := buildImage(name, "")
+	image.ImageId = aws.String(id)
+	image.OwnerId = aws.String(owner)
+	image.CreationDate = aws.String(created)
+	image.State = aws.String(ec2.ImageStateAvailable)
+
+	return image
+}
+
+// TestMatchImageExactRejectsSubstring ensures exact mode does not accept longer names.
+func TestMatchImageExactRejectsSubstring(t *testing.T) {
+	// Offer only an image whose name extends the requested name.
+	ref := &imageReference{Kind: imageReferenceOwnerAccount, Owner: "383156758163", Name: "k8s-1.27"}
+	images := []*ec2.Image{
+		buildOwnedImage("ami-old", "383156758163", "k8s-1.27-debian-old-20190101", "2019-01-01T00:00:00.000Z"),
+	}
+
+	// Exact mode must not match, fuzzy mode must.
+	if matchImage(ref, images, imageMatchModeExact) != nil {
+		t.Fatalf("expected exact mode to reject substring match")
+	}
+	if matchImage(ref, images, imageMatchModeFuzzy) == nil {
+		t.Fatalf("expected fuzzy mode to accept substring match")
+	}
+}
+
+// TestMatchImageExactRequiresOwner ensures exact mode compares the owner.
+func TestMatchImageExactRequiresOwner(t *testing.T) {
+	// Offer the right name from the wrong owner.
+	ref := &imageReference{Kind: imageReferenceOwnerAccount, Owner: "383156758163", Name: "k8s-1.27"}
+	images := []*ec2.Image{
+		buildOwnedImage("ami-other", "111111111111", "k8s-1.27", "2023-01-01T00:00:00.000Z"),
+	}
+
+	// The image must not match.
+	if matchImage(ref, images, imageMatchModeExact) != nil {
+		t.Fatalf("expected owner mismatch to be rejected")
+	}
+}
+
+// TestMatchImageExactWildcardNewest ensures wildcard names select the newest image.
+func TestMatchImageExactWildcardNewest(t *testing.T) {
+	// Offer two images matching a wildcard name.
+	ref := &imageReference{Kind: imageReferenceOwnerAccount, Owner: "099720109477", Name: "ubuntu/images/ubuntu-focal-*"}
+	images := []*ec2.Image{
+		buildOwnedImage("ami-older", "099720109477", "ubuntu/images/ubuntu-focal-20230101", "2023-01-01T00:00:00.000Z"),
+		buildOwnedImage("ami-newer", "099720109477", "ubuntu/images/ubuntu-focal-20230601", "2023-06-01T00:00:00.000Z"),
+		buildOwnedImage("ami-jammy", "099720109477", "ubuntu/images/ubuntu-jammy-20230701", "2023-07-01T00:00:00.000Z"),
+	}
+
+	// The newest matching image wins.
+	image := matchImage(ref, images, imageMatchModeExact)
+	if image == nil {
+		t.Fatalf("expected a wildcard match")
+	}
+	if aws.StringValue(image.ImageId) != "ami-newer" {
+		t.Fatalf("expected ami-newer, got %s", aws.StringValue(image.ImageId))
+	}
+}
+
+// buildLookupImages returns the images as the lookup results of every group.
+func buildLookupImages(groupImages []*instanceGroupImage, mode string, images []*ec2.Image) map[string][]*ec2.Image {
+	results := make(map[string][]*ec2.Image)
+	for _, groupImage := range groupImages {
+		results[imageLookupForReference(groupImage.Reference, groupImage.Region, mode).key()] = images
+	}
+
+	return results
+}
+
+// TestCheckImagesAreAvailableReportsMatches verifies matches and missing images are reported.
+func TestCheckImagesAreAvailableReportsMatches(t *testing.T) {
+	// Build one satisfiable and one missing reference.
+	found := &instanceGroupImage{
+		Group:     buildInstanceGroup("099720109477/ubuntu-focal"),
+		Reference: &imageReference{Raw: "099720109477/ubuntu-focal", Kind: imageReferenceOwnerAccount, Owner: "099720109477", Name: "ubuntu-focal"},
+	}
+	missing := &instanceGroupImage{
+		Group:     buildInstanceGroup("ami-0missing"),
+		Reference: &imageReference{Raw: "ami-0missing", Kind: imageReferenceID, ImageID: "ami-0missing"},
+	}
+	images := []*ec2.Image{
+		buildOwnedImage("ami-focal", "099720109477", "ubuntu-focal", "2023-01-01T00:00:00.000Z"),
+	}
+
+	// Check availability.
+	found.Region = "us-east-1"
+	missing.Region = "us-east-1"
+	matcher := &imageMatcher{Mode: imageMatchModeExact}
+	matches, errorMessages := checkImagesAreAvailable(context.Background(), []*instanceGroupImage{found, missing}, buildLookupImages([]*instanceGroupImage{found, missing}, matcher.Mode, images), matcher, nil)
+	if len(matches) != 1 || aws.StringValue(matches[0].Image.ImageId) != "ami-focal" {
+		t.Fatalf("expected ami-focal to satisfy the instance group, got %v", matches)
+	}
+	if len(errorMessages) != 1 {
+		t.Fatalf("expected one missing image, got %v", errorMessages)
+	}
+}
+
+// TestCheckImagesAreAvailableRejectsUnavailable verifies images that are not available are reported with their state.
+func TestCheckImagesAreAvailableRejectsUnavailable(t *testing.T) {
+	// Offer only a failed image.
+	failed := buildOwnedImage("ami-failed", "099720109477", "ubuntu-focal", "2023-01-01T00:00:00.000Z")
+	failed.State = aws.String(ec2.ImageStateFailed)
+	failed.StateReason = &ec2.StateReason{Message: aws.String("snapshot copy failed")}
+	groupImage := &instanceGroupImage{
+		Group:     buildInstanceGroup("099720109477/ubuntu-focal"),
+		Reference: &imageReference{Raw: "099720109477/ubuntu-focal", Kind: imageReferenceOwnerAccount, Owner: "099720109477", Name: "ubuntu-focal"},
+		Region:    "us-east-1",
+	}
+
+	// Requiring availability reports the state and reason.
+	matcher := &imageMatcher{Mode: imageMatchModeExact, RequireAvailable: true}
+	images := buildLookupImages([]*instanceGroupImage{groupImage}, matcher.Mode, []*ec2.Image{failed})
+	matches, errorMessages := checkImagesAreAvailable(context.Background(), []*instanceGroupImage{groupImage}, images, matcher, nil)
+	if len(matches) != 0 {
+		t.Fatalf("expected no matches, got %v", matches)
+	}
+	if len(errorMessages) != 1 || !strings.Contains(errorMessages[0], "state failed: snapshot copy failed") {
+		t.Fatalf("expected state and reason in message, got %v", errorMessages)
+	}
+
+	// Not requiring availability accepts the image.
+	matcher.RequireAvailable = false
+	matches, _ = checkImagesAreAvailable(context.Background(), []*instanceGroupImage{groupImage}, images, matcher, nil)
+	if len(matches) != 1 {
+		t.Fatalf("expected the failed image to match, got %v", matches)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ownerAliasAmazon is the EC2 owner alias for Amazon published images.
+	ownerAliasAmazon = "amazon"
+	// ownerAliasMarketplace is the EC2 owner alias for AWS Marketplace images.
+	ownerAliasMarketplace = "aws-marketplace"
+)
+
+// wellKnownOwnerAliases maps kops owner aliases to the account IDs that publish them.
+var wellKnownOwnerAliases = map[string]string{
+	"amazon.com": "137112412989",
+	"debian":     "136693071363",
+	"debian10":   "136693071363",
+	"debian11":   "136693071363",
+	"debian12":   "136693071363",
+	"flatcar":    "075585003325",
+	"kope.io":    "383156758163",
+	"redhat":     "309956199498",
+	"redhat.com": "309956199498",
+	"ubuntu":     "099720109477",
+}
+
+// ec2OwnerAliases lists the owner aliases EC2 understands natively.
+var ec2OwnerAliases = map[string]bool{
+	ownerAliasAmazon:      true,
+	ownerAliasMarketplace: true,
+	selfOwner:             true,
+}
+
+// resolveOwnerAlias maps a kops owner alias to its account ID, returning other owners unchanged.
+func resolveOwnerAlias(owner string) string {
+	// kops maps amazon to the Amazon Linux account for image references.
+	if owner == ownerAliasAmazon {
+		return wellKnownOwnerAliases["amazon.com"]
+	}
+
+	// Look up the remaining aliases.
+	account, ok := wellKnownOwnerAliases[owner]
+	if ok {
+		return account
+	}
+
+	return owner
+}
+
+// parseImageOwners parses a comma separated owner allowlist into account IDs and EC2 owner aliases.
+func parseImageOwners(value string) ([]string, error) {
+	// Prepare the result list.
+	owners := make([]string, 0)
+
+	// Validate each entry.
+	for _, entry := range strings.Split(value, ",") {
+		owner := strings.ToLower(strings.TrimSpace(entry))
+		if len(owner) == 0 {
+			continue
+		}
+
+		// Keep account IDs and EC2 aliases as written.
+		matched, err := regexp.MatchString(awsAccountIDPattern, owner)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse AMI_OWNERS: %w", err)
+		}
+		if matched || ec2OwnerAliases[owner] {
+			owners = append(owners, owner)
+			continue
+		}
+
+		// Map kops aliases to account IDs.
+		account, ok := wellKnownOwnerAliases[owner]
+		if !ok {
+			return nil, fmt.Errorf("AMI_OWNERS entry %s is not an account ID or known owner alias", owner)
+		}
+		owners = append(owners, account)
+	}
+
+	// Reject lists without any owners.
+	if len(owners) == 0 {
+		return nil, fmt.Errorf("AMI_OWNERS does not list any owners")
+	}
+
+	return owners, nil
+}
+
+// trustedOwners is the allowlist of owners whose images are considered valid.
+type trustedOwners struct {
+	// accounts holds trusted owner account IDs.
+	accounts map[string]bool
+	// aliases holds trusted EC2 owner aliases.
+	aliases map[string]bool
+}
+
+// newTrustedOwners builds the allowlist, replacing self with the calling account ID.
+func newTrustedOwners(owners []string, callerAccount string) *trustedOwners {
+	// Sort each owner into accounts or aliases.
+	trusted := &trustedOwners{
+		accounts: make(map[string]bool),
+		aliases:  make(map[string]bool),
+	}
+	for _, owner := range owners {
+		if owner == selfOwner {
+			trusted.accounts[callerAccount] = true
+			continue
+		}
+		if ec2OwnerAliases[owner] {
+			trusted.aliases[owner] = true
+			continue
+		}
+		trusted.accounts[owner] = true
+	}
+
+	return trusted
+}
+
+// allows reports whether an image is owned by a trusted owner.
+func (t *trustedOwners) allows(image *ec2.Image) bool {
+	// Guard against nil inputs.
+	if image == nil {
+		return false
+	}
+
+	// Match on the owner account or the owner alias.
+	if t.accounts[aws.StringValue(image.OwnerId)] {
+		return true
+	}
+
+	return t.aliases[aws.StringValue(image.ImageOwnerAlias)]
+}
+
+// loadTrustedOwners builds the configured owner allowlist, or nil when every owner is allowed.
+func loadTrustedOwners(ctx context.Context, cfg *CheckConfig, clients *awsClients) (*trustedOwners, error) {
+	// Allow every owner when no allowlist is configured.
+	if len(cfg.ImageOwners) == 0 {
+		log.Infoln("No AMI_OWNERS allowlist configured; images from any owner are accepted.")
+		return nil, nil
+	}
+
+	// Resolve the calling account only when self is trusted.
+	callerAccount := ""
+	for _, owner := range cfg.ImageOwners {
+		if owner != selfOwner {
+			continue
+		}
+		account, err := lookupCallerAccount(ctx, clients.stsClient(cfg.AWSRegion))
+		if err != nil {
+			return nil, err
+		}
+		callerAccount = account
+		break
+	}
+
+	log.Infoln("Trusted AMI owners:", strings.Join(cfg.ImageOwners, ", "))
+	return newTrustedOwners(cfg.ImageOwners, callerAccount), nil
+}
+
+// lookupCallerAccount returns the account ID of the credentials in use.
+func lookupCallerAccount(ctx context.Context, stsClient stsiface.STSAPI) (string, error) {
+	// Ask STS for the caller identity.
+	output, err := stsClient.GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", fmt.Errorf("failed to look up the calling AWS account: %w", err)
+	}
+	if output == nil || output.Account == nil {
+		return "", fmt.Errorf("caller identity did not include an account")
+	}
+
+	return *output.Account, nil
+}
+
+// checkImageOwners reports matched images whose owner is not in the allowlist.
+func checkImageOwners(matches []*imageMatch, trusted *trustedOwners) []string {
+	// Prepare the error list.
+	errorMessages := make([]string, 0)
+	if trusted == nil {
+		return errorMessages
+	}
+
+	// Check each matched image.
+	for _, match := range matches {
+		if trusted.allows(match.Image) {
+			continue
+		}
+		message := fmt.Sprintf("[%s] instance group %s image %s resolved to %s owned by %s, which is not a trusted owner",
+			match.Region, match.Group.Name, match.Reference.String(), aws.StringValue(match.Image.ImageId), aws.StringValue(match.Image.OwnerId))
+		errorMessages = append(errorMessages, message)
+	}
+
+	return errorMessages
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// TestParseImageOwners verifies account IDs, EC2 aliases, and kops aliases are accepted.
+func TestParseImageOwners(t *testing.T) {
+	// Parse a mixed allowlist.
+	owners, err := parseImageOwners("123456789012, amazon,self,kope.io, Ubuntu")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Validate the normalized owners.
+	expected := []string{"123456789012", "amazon", "self", "383156758163", "099720109477"}
+	if len(owners) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, owners)
+	}
+	for i := range expected {
+		if owners[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, owners)
+		}
+	}
+
+	// Reject unknown aliases and empty lists.
+	_, err = parseImageOwners("coreos")
+	if err == nil {
+		t.Fatalf("expected error for unknown alias")
+	}
+	_, err = parseImageOwners(" , ")
+	if err == nil {
+		t.Fatalf("expected error for empty list")
+	}
+}
+
+// TestCheckImageOwners verifies images from untrusted owners are reported.
+func TestCheckImageOwners(t *testing.T) {
+	// Trust one account, the amazon alias, and the calling account.
+	trusted := newTrustedOwners([]string{"099720109477", ownerAliasAmazon, selfOwner}, "222222222222")
+
+	// Build matches for trusted and untrusted images.
+	amazonImage := buildOwnedImage("ami-al2023", "137112412989", "al2023", "2023-01-01T00:00:00.000Z")
+	amazonImage.ImageOwnerAlias = aws.String(ownerAliasAmazon)
+	images := []*ec2.Image{
+		buildOwnedImage("ami-focal", "099720109477", "ubuntu-focal", "2023-01-01T00:00:00.000Z"),
+		amazonImage,
+		buildOwnedImage("ami-baked", "222222222222", "baked", "2023-01-01T00:00:00.000Z"),
+		buildOwnedImage("ami-rogue", "111111111111", "ubuntu-focal", "2023-01-01T00:00:00.000Z"),
+	}
+	matches := make([]*imageMatch, 0)
+	for _, image := range images {
+		matches = append(matches, &imageMatch{
+			Group:     buildInstanceGroup("image"),
+			Reference: &imageReference{Raw: "image"},
+			Image:     image,
+		})
+	}
+
+	// Only the rogue image is reported.
+	errorMessages := checkImageOwners(matches, trusted)
+	if len(errorMessages) != 1 {
+		t.Fatalf("expected one untrusted image, got %v", errorMessages)
+	}
+
+	// No allowlist accepts everything.
+	errorMessages = checkImageOwners(matches, nil)
+	if len(errorMessages) != 0 {
+		t.Fatalf("expected no errors without an allowlist, got %v", errorMessages)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/kops/pkg/apis/kops"
+)
+
+const (
+	// imageIDPrefix marks a raw AMI ID reference.
+	imageIDPrefix = "ami-"
+	// ssmParameterPrefix marks an SSM parameter reference.
+	ssmParameterPrefix = "ssm:"
+	// selfOwner is the DescribeImages owner value for the calling account.
+	selfOwner = "self"
+	// awsAccountIDPattern validates twelve digit AWS account IDs.
+	awsAccountIDPattern = `^\d{12}$`
+)
+
+// imageReferenceKind classifies the form of a kops image reference.
+type imageReferenceKind string
+
+const (
+	// imageReferenceID is a raw AMI ID such as ami-0abc123.
+	imageReferenceID imageReferenceKind = "image-id"
+	// imageReferenceName is a bare image name owned by the calling account.
+	imageReferenceName imageReferenceKind = "name"
+	// imageReferenceOwnerAlias is an owner-alias/name reference such as kope.io/k8s-1.27.
+	imageReferenceOwnerAlias imageReferenceKind = "owner-alias"
+	// imageReferenceOwnerAccount is an owner-account/name reference such as 099720109477/ubuntu/images/....
+	imageReferenceOwnerAccount imageReferenceKind = "owner-account"
+	// imageReferenceSSMParameter is an ssm:/path reference resolved through Systems Manager.
+	imageReferenceSSMParameter imageReferenceKind = "ssm-parameter"
+)
+
+// imageReference is a classified kops image reference.
+type imageReference struct {
+	// Raw is the reference exactly as written in the instance group spec.
+	Raw string
+	// Kind classifies the reference form.
+	Kind imageReferenceKind
+	// Owner is the account ID or EC2 owner alias the image is looked up under.
+	Owner string
+	// Name is the AMI name, which may contain EC2 filter wildcards.
+	Name string
+	// ImageID is the AMI ID for ID references and resolved SSM references.
+	ImageID string
+	// Parameter is the SSM parameter name for SSM references.
+	Parameter string
+}
+
+// String renders the reference for log and report messages.
+func (r *imageReference) String() string {
+	// Include the resolved AMI ID for SSM references.
+	if r.Kind == imageReferenceSSMParameter && len(r.ImageID) != 0 {
+		return fmt.Sprintf("%s (%s)", r.Raw, r.ImageID)
+	}
+
+	return r.Raw
+}
+
+// instanceGroupImage pairs an instance group with its image reference.
+type instanceGroupImage struct {
+	// Group is the kops instance group.
+	Group *kops.InstanceGroup
+	// Reference is the classified and resolved image reference.
+	Reference *imageReference
+	// Region is the region the reference was resolved in.
+	Region string
+}
+
+// parseImageReference classifies a kops image reference the way kops resolves it.
+func parseImageReference(raw string) (*imageReference, error) {
+	// Validate the reference.
+	value := strings.TrimSpace(raw)
+	if len(value) == 0 {
+		return nil, fmt.Errorf("image reference is empty")
+	}
+	ref := &imageReference{Raw: value}
+
+	// Handle raw AMI IDs.
+	if strings.HasPrefix(value, imageIDPrefix) {
+		ref.Kind = imageReferenceID
+		ref.ImageID = value
+		return ref, nil
+	}
+
+	// Handle SSM parameter references.
+	if strings.HasPrefix(value, ssmParameterPrefix) {
+		parameter := strings.TrimPrefix(value, ssmParameterPrefix)
+		if len(parameter) == 0 {
+			return nil, fmt.Errorf("image reference %s does not name an SSM parameter", value)
+		}
+		ref.Kind = imageReferenceSSMParameter
+		ref.Parameter = parameter
+		return ref, nil
+	}
+
+	// A bare name is owned by the calling account.
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) == 1 {
+		ref.Kind = imageReferenceName
+		ref.Owner = selfOwner
+		ref.Name = value
+		return ref, nil
+	}
+
+	// Everything after the first slash is the image name.
+	owner := parts[0]
+	name := parts[1]
+	if len(owner) == 0 || len(name) == 0 {
+		return nil, fmt.Errorf("image reference %s is not in owner/name format", value)
+	}
+	ref.Name = name
+
+	// Account IDs are used as-is.
+	matched, err := regexp.MatchString(awsAccountIDPattern, owner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse image owner: %w", err)
+	}
+	if matched {
+		ref.Kind = imageReferenceOwnerAccount
+		ref.Owner = owner
+		return ref, nil
+	}
+
+	// Aliases kops knows map to accounts; others pass through to EC2.
+	ref.Kind = imageReferenceOwnerAlias
+	ref.Owner = resolveOwnerAlias(owner)
+
+	return ref, nil
+}
+
+// resolveInstanceGroupImages classifies the image reference of each instance group and resolves it in every region the group launches in.
+func resolveInstanceGroupImages(ctx context.Context, cfg *CheckConfig, clients *awsClients, instanceGroups []*kops.InstanceGroup) ([]*instanceGroupImage, []string) {
+	// Prepare the results.
+	results := make([]*instanceGroupImage, 0)
+	errorMessages := make([]string, 0)
+
+	// Iterate each instance group.
+	for _, group := range instanceGroups {
+		// Skip nil groups defensively.
+		if group == nil {
+			continue
+		}
+
+		// Classify the image reference.
+		parsed, err := instanceGroupImageReference(group)
+		if err != nil {
+			errorMessages = append(errorMessages, err.Error())
+			continue
+		}
+
+		// Resolve the reference separately in each region.
+		for _, region := range instanceGroupRegions(cfg, group) {
+			ref := *parsed
+
+			// SSM parameters resolve to region specific AMI IDs.
+			if ref.Kind == imageReferenceSSMParameter {
+				err = resolveSSMImageReference(ctx, clients.ssmClient(region), &ref)
+				if err != nil {
+					message := fmt.Sprintf("instance group %s in %s: %s", group.Name, region, err.Error())
+					errorMessages = append(errorMessages, message)
+					continue
+				}
+			}
+
+			log.Infof("Instance group %s references image %s as %s in %s.", group.Name, ref.String(), ref.Kind, region)
+			results = append(results, &instanceGroupImage{Group: group, Reference: &ref, Region: region})
+		}
+	}
+
+	return results, errorMessages
+}
+
+// instanceGroupImageReference classifies the image reference of an instance group.
+func instanceGroupImageReference(group *kops.InstanceGroup) (*imageReference, error) {
+	// Validate the image field.
+	if group == nil {
+		return nil, fmt.Errorf("instance group was nil")
+	}
+	if len(group.Spec.Image) == 0 {
+		return nil, fmt.Errorf("instance group %s does not define an image", group.Name)
+	}
+
+	// Parse the reference.
+	ref, err := parseImageReference(group.Spec.Image)
+	if err != nil {
+		return nil, fmt.Errorf("instance group %s: %w", group.Name, err)
+	}
+
+	return ref, nil
+}
+
+// resolveSSMImageReference looks up the AMI ID stored in an SSM parameter.
+func resolveSSMImageReference(ctx context.Context, ssmClient ssmiface.SSMAPI, ref *imageReference) error {
+	// Request the parameter value.
+	log.Infoln("Resolving SSM parameter:", ref.Parameter)
+	output, err := ssmClient.GetParameterWithContext(ctx, &ssm.GetParameterInput{
+		Name: aws.String(ref.Parameter),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to resolve SSM parameter %s: %w", ref.Parameter, err)
+	}
+
+	// Validate the parameter value.
+	if output == nil || output.Parameter == nil || output.Parameter.Value == nil {
+		return fmt.Errorf("SSM parameter %s has no value", ref.Parameter)
+	}
+	imageID := strings.TrimSpace(*output.Parameter.Value)
+	if !strings.HasPrefix(imageID, imageIDPrefix) {
+		return fmt.Errorf("SSM parameter %s does not contain an AMI ID: %s", ref.Parameter, imageID)
+	}
+
+	ref.ImageID = imageID
+	return nil
+}
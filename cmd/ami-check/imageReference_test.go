@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"k8s.io/kops/pkg/apis/kops"
+)
+
+// fakeSSM serves SSM parameters from memory.
+type fakeSSM struct {
+	ssmiface.SSMAPI
+	parameters map[string]string
+}
+
+// GetParameterWithContext returns the stored parameter value or an error.
+func (f *fakeSSM) GetParameterWithContext(ctx context.Context, input *ssm.GetParameterInput, opts ...request.Option) (*ssm.GetParameterOutput, error) {
+	// Look up the parameter by name.
+	value, ok := f.parameters[aws.StringValue(input.Name)]
+	if !ok {
+		return nil, fmt.Errorf("parameter not found")
+	}
+
+	return &ssm.GetParameterOutput{Parameter: &ssm.Parameter{Value: aws.String(value)}}, nil
+}
+
+// TestParseImageReference verifies classification of each kops reference form.
+func TestParseImageReference(t *testing.T) {
+	// Describe each reference form and its expected classification.
+	cases := []struct {
+		raw     string
+		kind    imageReferenceKind
+		owner   string
+		name    string
+		imageID string
+	}{
+		{raw: "ami-0abc123", kind: imageReferenceID, imageID: "ami-0abc123"},
+		{raw: "ssm:/aws/service/canonical/ubuntu/server/focal/stable/current/amd64/hvm/ebs-gp2/ami-id", kind: imageReferenceSSMParameter},
+		{raw: "my-image", kind: imageReferenceName, owner: selfOwner, name: "my-image"},
+		{raw: "kope.io/k8s-1.27", kind: imageReferenceOwnerAlias, owner: "383156758163", name: "k8s-1.27"},
+		{raw: "amazon/al2023-ami-2023", kind: imageReferenceOwnerAlias, owner: "137112412989", name: "al2023-ami-2023"},
+		{raw: "aws-marketplace/product", kind: imageReferenceOwnerAlias, owner: "aws-marketplace", name: "product"},
+		{raw: "ubuntu/ubuntu-focal-20.04", kind: imageReferenceOwnerAlias, owner: "099720109477", name: "ubuntu-focal-20.04"},
+		{raw: "099720109477/ubuntu/images/hvm-ssd/ubuntu-focal-20.04-amd64-server-20230502", kind: imageReferenceOwnerAccount, owner: "099720109477", name: "ubuntu/images/hvm-ssd/ubuntu-focal-20.04-amd64-server-20230502"},
+	}
+
+	// Parse and compare each case.
+	for _, c := range cases {
+		ref, err := parseImageReference(c.raw)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", c.raw, err)
+		}
+		if ref.Kind != c.kind {
+			t.Fatalf("expected kind %s for %s, got %s", c.kind, c.raw, ref.Kind)
+		}
+		if ref.Owner != c.owner {
+			t.Fatalf("expected owner %s for %s, got %s", c.owner, c.raw, ref.Owner)
+		}
+		if ref.Name != c.name {
+			t.Fatalf("expected name %s for %s, got %s", c.name, c.raw, ref.Name)
+		}
+		if ref.ImageID != c.imageID {
+			t.Fatalf("expected image ID %s for %s, got %s", c.imageID, c.raw, ref.ImageID)
+		}
+	}
+}
+
+// TestParseImageReferenceInvalid ensures malformed references error.
+func TestParseImageReferenceInvalid(t *testing.T) {
+	// Check each malformed reference.
+	for _, raw := range []string{"", "ssm:", "/name", "owner/"} {
+		_, err := parseImageReference(raw)
+		if err == nil {
+			t.Fatalf("expected error for %q", raw)
+		}
+	}
+}
+
+// TestResolveImageReferencesSSM verifies SSM parameters resolve to AMI IDs.
+func TestResolveImageReferencesSSM(t *testing.T) {
+	// Serve one valid and one invalid parameter.
+	client := &fakeSSM{parameters: map[string]string{
+		"/ami/good": "ami-0abc123",
+		"/ami/bad":  "not-an-ami",
+	}}
+	good := buildInstanceGroup("ssm:/ami/good")
+	bad := buildInstanceGroup("ssm:/ami/bad")
+	missing := buildInstanceGroup("ssm:/ami/missing")
+
+	// Resolve the references.
+	cfg := &CheckConfig{EC2Regions: []string{"us-east-1"}}
+	results, errorMessages := resolveInstanceGroupImages(context.Background(), cfg, buildFakeClients(nil, client), []*kops.InstanceGroup{good, bad, missing})
+	if len(results) != 1 {
+		t.Fatalf("expected one resolved reference, got %d", len(results))
+	}
+	if results[0].Reference.ImageID != "ami-0abc123" {
+		t.Fatalf("expected ami-0abc123, got %s", results[0].Reference.ImageID)
+	}
+	if len(errorMessages) != 2 {
+		t.Fatalf("expected two errors, got %v", errorMessages)
+	}
+}
+
+// TestResolveInstanceGroupImagesPerRegion verifies SSM references resolve separately in each zone's region.
+func TestResolveInstanceGroupImagesPerRegion(t *testing.T) {
+	// Serve a different AMI ID from each region.
+	clients := &awsClients{newSSM: func(region string) ssmiface.SSMAPI {
+		return &fakeSSM{parameters: map[string]string{"/ami/current": "ami-" + region}}
+	}}
+	group := buildInstanceGroup("ssm:/ami/current")
+	group.Spec.Zones = []string{"us-east-1a", "us-east-1b", "eu-west-1a"}
+
+	// Resolve the reference.
+	cfg := &CheckConfig{EC2Regions: []string{"us-west-2"}}
+	results, errorMessages := resolveInstanceGroupImages(context.Background(), cfg, clients, []*kops.InstanceGroup{group})
+	if len(errorMessages) != 0 {
+		t.Fatalf("unexpected errors: %v", errorMessages)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected two regional references, got %d", len(results))
+	}
+	if results[0].Region != "us-east-1" || results[0].Reference.ImageID != "ami-us-east-1" {
+		t.Fatalf("unexpected us-east-1 resolution: %s %s", results[0].Region, results[0].Reference.ImageID)
+	}
+	if results[1].Region != "eu-west-1" || results[1].Reference.ImageID != "ami-eu-west-1" {
+		t.Fatalf("unexpected eu-west-1 resolution: %s %s", results[1].Region, results[1].Reference.ImageID)
+	}
+}
+
+// TestRegionFromZone verifies regions are derived from availability and local zones.
+func TestRegionFromZone(t *testing.T) {
+	// Check each zone form.
+	cases := map[string]string{
+		"us-east-1a":       "us-east-1",
+		"eu-central-1c":    "eu-central-1",
+		"us-west-2-lax-1a": "us-west-2",
+	}
+	for zone, expected := range cases {
+		region, ok := regionFromZone(zone)
+		if !ok || region != expected {
+			t.Fatalf("expected %s for %s, got %s", expected, zone, region)
+		}
+	}
+
+	// Reject names that are not zones.
+	_, ok := regionFromZone("nowhere")
+	if ok {
+		t.Fatalf("expected invalid zone to be rejected")
+	}
+}
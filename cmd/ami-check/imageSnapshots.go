@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/kms"
+	log "github.com/sirupsen/logrus"
+)
+
+// snapshotValidator inspects the EBS snapshots and KMS keys behind matched images, caching each lookup.
+type snapshotValidator struct {
+	// clients builds the region scoped EC2 and KMS clients.
+	clients *awsClients
+	// clusterAccount is the account the cluster launches nodes in.
+	clusterAccount string
+	// snapshots caches described snapshots by region and ID; nil records a snapshot that was not found.
+	snapshots map[string]*ec2.Snapshot
+	// keys caches the accessibility problem of each KMS key by region and ID; empty means usable.
+	keys map[string]string
+}
+
+// newSnapshotValidator builds a validator for the cluster account.
+func newSnapshotValidator(clients *awsClients, clusterAccount string) *snapshotValidator {
+	return &snapshotValidator{
+		clients:        clients,
+		clusterAccount: clusterAccount,
+		snapshots:      make(map[string]*ec2.Snapshot),
+		keys:           make(map[string]string),
+	}
+}
+
+// checkImageSnapshots reports matched images whose backing snapshots are missing, broken, or encrypted with unusable keys.
+func checkImageSnapshots(ctx context.Context, clients *awsClients, matches []*imageMatch, clusterAccount string) ([]string, []string, error) {
+	// Prepare the results.
+	failures := make([]string, 0)
+	warnings := make([]string, 0)
+	validator := newSnapshotValidator(clients, clusterAccount)
+	log.Infoln("Checking EBS snapshots and KMS keys backing matched AMIs.")
+
+	// Inspect each EBS mapping of each matched image.
+	for _, match := range matches {
+		prefix := fmt.Sprintf("[%s] instance group %s image %s (%s)",
+			match.Region, match.Group.Name, match.Reference.String(), aws.StringValue(match.Image.ImageId))
+		for _, mapping := range match.Image.BlockDeviceMappings {
+			if mapping == nil || mapping.Ebs == nil || len(aws.StringValue(mapping.Ebs.SnapshotId)) == 0 {
+				continue
+			}
+			deviceName := aws.StringValue(mapping.DeviceName)
+			snapshotID := aws.StringValue(mapping.Ebs.SnapshotId)
+
+			// Describe the snapshot.
+			snapshot, err := validator.describeSnapshot(ctx, match.Region, snapshotID)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			// Snapshots of images owned elsewhere are usually not visible to the cluster account.
+			if snapshot == nil {
+				if aws.StringValue(match.Image.OwnerId) == clusterAccount {
+					failures = append(failures, fmt.Sprintf("%s: snapshot %s for %s does not exist", prefix, snapshotID, deviceName))
+					continue
+				}
+				warnings = append(warnings, fmt.Sprintf("%s: snapshot %s for %s is not visible to account %s and was not verified", prefix, snapshotID, deviceName, clusterAccount))
+				continue
+			}
+
+			// Fail snapshots that cannot be restored.
+			if aws.StringValue(snapshot.State) == ec2.SnapshotStateError {
+				failures = append(failures, fmt.Sprintf("%s: snapshot %s for %s is in state %s", prefix, snapshotID, deviceName, aws.StringValue(snapshot.State)))
+				continue
+			}
+
+			// Confirm the encryption key can be used.
+			keyID := aws.StringValue(snapshot.KmsKeyId)
+			if !aws.BoolValue(snapshot.Encrypted) || len(keyID) == 0 {
+				continue
+			}
+			problem, err := validator.describeKey(ctx, match.Region, keyID)
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(problem) != 0 {
+				failures = append(failures, fmt.Sprintf("%s: snapshot %s for %s is encrypted with KMS key %s, which %s", prefix, snapshotID, deviceName, keyID, problem))
+			}
+		}
+	}
+
+	return failures, warnings, nil
+}
+
+// describeSnapshot fetches a snapshot once per region, returning nil when it is not found.
+func (v *snapshotValidator) describeSnapshot(ctx context.Context, region string, snapshotID string) (*ec2.Snapshot, error) {
+	// Serve repeated snapshots from the cache.
+	cacheKey := region + "/" + snapshotID
+	snapshot, ok := v.snapshots[cacheKey]
+	if ok {
+		return snapshot, nil
+	}
+
+	// Query the single snapshot so a missing one does not hide the others.
+	log.Debugln("Describing snapshot", snapshotID, "in", region)
+	output, err := v.clients.ec2Client(region).DescribeSnapshotsWithContext(ctx, &ec2.DescribeSnapshotsInput{
+		SnapshotIds: aws.StringSlice([]string{snapshotID}),
+	})
+	if err != nil && awsErrorCode(err) != "InvalidSnapshot.NotFound" {
+		return nil, fmt.Errorf("failed to describe snapshot %s in %s: %w", snapshotID, region, err)
+	}
+	if err == nil && len(output.Snapshots) != 0 {
+		snapshot = output.Snapshots[0]
+	}
+	v.snapshots[cacheKey] = snapshot
+
+	return snapshot, nil
+}
+
+// describeKey checks a KMS key once per region, returning why it cannot be used or an empty string.
+func (v *snapshotValidator) describeKey(ctx context.Context, region string, keyID string) (string, error) {
+	// Serve repeated keys from the cache.
+	cacheKey := region + "/" + keyID
+	problem, ok := v.keys[cacheKey]
+	if ok {
+		return problem, nil
+	}
+
+	// Describe the key, treating missing and denied keys as findings.
+	log.Debugln("Describing KMS key", keyID, "in", region)
+	output, err := v.clients.kmsClient(region).DescribeKeyWithContext(ctx, &kms.DescribeKeyInput{
+		KeyId: aws.String(keyID),
+	})
+	switch {
+	case err == nil:
+		if output.KeyMetadata != nil && aws.StringValue(output.KeyMetadata.KeyState) != kms.KeyStateEnabled {
+			problem = fmt.Sprintf("is in state %s", aws.StringValue(output.KeyMetadata.KeyState))
+		}
+	case awsErrorCode(err) == kms.ErrCodeNotFoundException:
+		problem = "does not exist"
+	case awsErrorCode(err) == "AccessDeniedException":
+		problem = fmt.Sprintf("is not accessible to account %s", v.clusterAccount)
+	default:
+		return "", fmt.Errorf("failed to describe KMS key %s in %s: %w", keyID, region, err)
+	}
+	v.keys[cacheKey] = problem
+
+	return problem, nil
+}
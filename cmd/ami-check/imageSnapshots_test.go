@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+)
+
+// fakeSnapshotEC2 serves snapshots from memory.
+type fakeSnapshotEC2 struct {
+	ec2iface.EC2API
+	snapshots map[string]*ec2.Snapshot
+	calls     int
+}
+
+// DescribeSnapshotsWithContext returns the stored snapshot or a not found error.
+func (f *fakeSnapshotEC2) DescribeSnapshotsWithContext(ctx context.Context, input *ec2.DescribeSnapshotsInput, opts ...request.Option) (*ec2.DescribeSnapshotsOutput, error) {
+	// Count the call.
+	f.calls++
+
+	// Return each requested snapshot.
+	output := &ec2.DescribeSnapshotsOutput{}
+	for _, snapshotID := range aws.StringValueSlice(input.SnapshotIds) {
+		snapshot, ok := f.snapshots[snapshotID]
+		if !ok {
+			return nil, awserr.New("InvalidSnapshot.NotFound", "snapshot does not exist", nil)
+		}
+		output.Snapshots = append(output.Snapshots, snapshot)
+	}
+
+	return output, nil
+}
+
+// fakeKMS serves key states from memory and denies unknown keys.
+type fakeKMS struct {
+	kmsiface.KMSAPI
+	keyStates map[string]string
+}
+
+// DescribeKeyWithContext returns the stored key state or an access denied error.
+func (f *fakeKMS) DescribeKeyWithContext(ctx context.Context, input *kms.DescribeKeyInput, opts ...request.Option) (*kms.DescribeKeyOutput, error) {
+	// Deny keys that are not stored.
+	state, ok := f.keyStates[aws.StringValue(input.KeyId)]
+	if !ok {
+		return nil, awserr.New("AccessDeniedException", "access denied", nil)
+	}
+
+	return &kms.DescribeKeyOutput{KeyMetadata: &kms.KeyMetadata{KeyId: input.KeyId, KeyState: aws.String(state)}}, nil
+}
+
+// buildSnapshotMatch builds a match for an image backed by the given snapshots.
+func buildSnapshotMatch(name string, owner string, snapshotIDs ...string) *imageMatch {
+	// Map each snapshot to a device.
+	image := buildOwnedImage("ami-"+name, owner, name, "2023-01-01T00:00:00.000Z")
+	for i, snapshotID := range snapshotIDs {
+		image.BlockDeviceMappings = append(image.BlockDeviceMappings, &ec2.BlockDeviceMapping{
+			DeviceName: aws.String("/dev/xvd" + string(rune('a'+i))),
+			Ebs:        &ec2.EbsBlockDevice{SnapshotId: aws.String(snapshotID)},
+		})
+	}
+
+	return &imageMatch{Group: buildInstanceGroup(owner + "/" + name), Reference: &imageReference{Raw: owner + "/" + name}, Image: image, Region: "us-east-1"}
+}
+
+// TestCheckImageSnapshots verifies missing snapshots, broken snapshots, and unusable keys are reported.
+func TestCheckImageSnapshots(t *testing.T) {
+	// Serve snapshots in each interesting state.
+	ec2Client := &fakeSnapshotEC2{snapshots: map[string]*ec2.Snapshot{
+		"snap-ok":       {SnapshotId: aws.String("snap-ok"), State: aws.String(ec2.SnapshotStateCompleted)},
+		"snap-error":    {SnapshotId: aws.String("snap-error"), State: aws.String(ec2.SnapshotStateError)},
+		"snap-disabled": {SnapshotId: aws.String("snap-disabled"), State: aws.String(ec2.SnapshotStateCompleted), Encrypted: aws.Bool(true), KmsKeyId: aws.String("key-disabled")},
+		"snap-denied":   {SnapshotId: aws.String("snap-denied"), State: aws.String(ec2.SnapshotStateCompleted), Encrypted: aws.Bool(true), KmsKeyId: aws.String("key-denied")},
+	}}
+	clients := buildFakeClients(ec2Client, nil)
+	clients.newKMS = func(region string) kmsiface.KMSAPI {
+		return &fakeKMS{keyStates: map[string]string{"key-disabled": kms.KeyStateDisabled}}
+	}
+	matches := []*imageMatch{
+		buildSnapshotMatch("owned", "111111111111", "snap-ok", "snap-missing", "snap-error"),
+		buildSnapshotMatch("encrypted", "111111111111", "snap-disabled", "snap-denied"),
+		buildSnapshotMatch("foreign", "099720109477", "snap-hidden"),
+		buildSnapshotMatch("repeat", "111111111111", "snap-ok"),
+	}
+
+	// Check the snapshots.
+	failures, warnings, err := checkImageSnapshots(context.Background(), clients, matches, "111111111111")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(failures) != 4 {
+		t.Fatalf("expected four failures, got %v", failures)
+	}
+	expected := []string{"snap-missing for /dev/xvdb does not exist", "snap-error for /dev/xvdc is in state error", "key-disabled, which is in state Disabled", "key-denied, which is not accessible"}
+	for i, fragment := range expected {
+		if !strings.Contains(failures[i], fragment) {
+			t.Fatalf("expected failure %d to contain %q, got %s", i, fragment, failures[i])
+		}
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "snap-hidden") {
+		t.Fatalf("expected a warning for the foreign snapshot, got %v", warnings)
+	}
+	if ec2Client.calls != 6 {
+		t.Fatalf("expected repeated snapshots to be cached, got %d calls", ec2Client.calls)
+	}
+}
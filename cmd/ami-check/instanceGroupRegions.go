@@ -0,0 +1,63 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"k8s.io/kops/pkg/apis/kops"
+)
+
+// regionFromZone derives the region of an availability zone or local zone name.
+func regionFromZone(zone string) (string, bool) {
+	// Drop the zone letter.
+	candidate := strings.TrimRight(strings.TrimSpace(zone), "abcdefghijklmnopqrstuvwxyz")
+
+	// Trim local zone segments until a region remains.
+	for len(candidate) != 0 {
+		matched, err := regexp.MatchString(awsRegionPattern, candidate)
+		if err == nil && matched {
+			return candidate, true
+		}
+		index := strings.LastIndex(candidate, "-")
+		if index < 0 {
+			break
+		}
+		candidate = candidate[:index]
+	}
+
+	return "", false
+}
+
+// instanceGroupRegions returns the regions an instance group launches in, falling back to the configured EC2 regions.
+func instanceGroupRegions(cfg *CheckConfig, group *kops.InstanceGroup) []string {
+	// Derive regions from the instance group zones.
+	regions := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, zone := range group.Spec.Zones {
+		region, ok := regionFromZone(zone)
+		if !ok || seen[region] {
+			continue
+		}
+		seen[region] = true
+		regions = append(regions, region)
+	}
+	if len(regions) != 0 {
+		return regions
+	}
+
+	return cfg.EC2Regions
+}
+
+// instanceGroupRegionZones returns the zones of an instance group that belong to a region.
+func instanceGroupRegionZones(group *kops.InstanceGroup, region string) []string {
+	// Keep the zones whose region matches.
+	zones := make([]string, 0)
+	for _, zone := range group.Spec.Zones {
+		zoneRegion, ok := regionFromZone(zone)
+		if ok && zoneRegion == region {
+			zones = append(zones, zone)
+		}
+	}
+
+	return zones
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/kops/pkg/apis/kops"
+)
+
+// instanceGroupMachineTypes lists the instance types an instance group can launch, including its mixed instances policy.
+func instanceGroupMachineTypes(group *kops.InstanceGroup) []string {
+	// Collect the machine type field, which may list several types, and the mixed instance overrides.
+	entries := strings.Split(group.Spec.MachineType, ",")
+	policy := instanceGroupMixedInstancesPolicy(group)
+	if policy != nil {
+		entries = append(entries, policy.Instances...)
+	}
+
+	// Keep each type once, in order.
+	machineTypes := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		machineType := strings.TrimSpace(entry)
+		if len(machineType) == 0 || seen[machineType] {
+			continue
+		}
+		seen[machineType] = true
+		machineTypes = append(machineTypes, machineType)
+	}
+
+	return machineTypes
+}
+
+// instanceGroupMixedInstancesPolicy returns the mixed instances policy kops applies to a group, or nil.
+func instanceGroupMixedInstancesPolicy(group *kops.InstanceGroup) *kops.MixedInstancesPolicySpec {
+	// Karpenter managed groups do not launch through an autoscaling group.
+	if group.Spec.Manager == kops.InstanceManagerKarpenter {
+		return nil
+	}
+
+	return group.Spec.MixedInstancesPolicy
+}
+
+const (
+	// describeInstanceTypesPermission lets the check compare images with instance types.
+	describeInstanceTypesPermission = "ec2:DescribeInstanceTypes"
+	// describeInstanceTypeOfferingsPermission lets the check verify zone offerings.
+	describeInstanceTypeOfferingsPermission = "ec2:DescribeInstanceTypeOfferings"
+	// instanceRequirementsPermission lets the check resolve attribute based instance requirements.
+	instanceRequirementsPermission = "ec2:GetInstanceTypesFromInstanceRequirements"
+)
+
+// permissionWarnings records a single warning for each AWS permission the check role lacks.
+type permissionWarnings struct {
+	// denied holds the permissions that were refused.
+	denied map[string]bool
+	// warnings describe the checks skipped for each refused permission.
+	warnings []string
+}
+
+// lacks reports whether the permission was already refused.
+func (p *permissionWarnings) lacks(permission string) bool {
+	return p.denied[permission]
+}
+
+// skip reports whether an error refused the permission, warning about the skipped checks the first time.
+func (p *permissionWarnings) skip(permission string, err error) bool {
+	// Only authorization failures are skipped.
+	if awsErrorCode(err) != "UnauthorizedOperation" {
+		return false
+	}
+
+	// Warn once per permission.
+	if !p.denied[permission] {
+		if p.denied == nil {
+			p.denied = make(map[string]bool)
+		}
+		p.denied[permission] = true
+		p.warnings = append(p.warnings, fmt.Sprintf("skipped the instance type checks that need %s, which the check role is not granted: %s", permission, err.Error()))
+	}
+
+	return true
+}
+
+// checkInstanceTypeCompatibility reports matched images that cannot boot on, or are not offered with, the instance types of their group.
+// Checks needing a permission the role lacks are skipped and returned as warnings.
+func checkInstanceTypeCompatibility(ctx context.Context, clients *awsClients, matches []*imageMatch) ([]string, []string, error) {
+	// Prepare the error list and per-region instance type and offering caches.
+	errorMessages := make([]string, 0)
+	permissions := &permissionWarnings{}
+	infos := make(map[string]map[string]*ec2.InstanceTypeInfo)
+	offerings := make(map[string]map[string][]string)
+
+	// Inspect each instance type of each matched group.
+	for _, match := range matches {
+		// Warm pool instances are stopped, which instance store backed images cannot be.
+		if match.Group.Spec.WarmPool.IsEnabled() && aws.StringValue(match.Image.RootDeviceType) != ec2.DeviceTypeEbs {
+			message := fmt.Sprintf("[%s] instance group %s has a warm pool but image %s (%s) is %s backed and cannot be stopped",
+				match.Region, match.Group.Name, match.Reference.String(), aws.StringValue(match.Image.ImageId), aws.StringValue(match.Image.RootDeviceType))
+			errorMessages = append(errorMessages, message)
+		}
+
+		// Attribute based selection must leave at least one type for the image.
+		policy := instanceGroupMixedInstancesPolicy(match.Group)
+		if policy != nil && policy.InstanceRequirements != nil && !permissions.lacks(instanceRequirementsPermission) {
+			selected, err := instanceRequirementsTypes(ctx, clients, match.Region, match.Image, policy.InstanceRequirements)
+			if err != nil && !permissions.skip(instanceRequirementsPermission, err) {
+				return nil, nil, err
+			}
+			if err == nil && len(selected) == 0 {
+				message := fmt.Sprintf("[%s] instance group %s instance requirements match no %s instance types for image %s (%s)",
+					match.Region, match.Group.Name, aws.StringValue(match.Image.Architecture), match.Reference.String(), aws.StringValue(match.Image.ImageId))
+				errorMessages = append(errorMessages, message)
+			}
+		}
+
+		for _, machineType := range instanceGroupMachineTypes(match.Group) {
+			// Compare the image with the type unless the role may not describe it.
+			if !permissions.lacks(describeInstanceTypesPermission) {
+				if infos[match.Region] == nil {
+					infos[match.Region] = make(map[string]*ec2.InstanceTypeInfo)
+				}
+				info, ok := infos[match.Region][machineType]
+				if !ok {
+					described, err := describeInstanceType(ctx, clients, match.Region, machineType)
+					if err != nil && !permissions.skip(describeInstanceTypesPermission, err) {
+						return nil, nil, err
+					}
+					info = described
+					infos[match.Region][machineType] = info
+				}
+
+				// Report types the region does not offer, which a refused request cannot tell.
+				if info == nil && !permissions.lacks(describeInstanceTypesPermission) {
+					message := fmt.Sprintf("[%s] instance group %s machine type %s is not offered in the region", match.Region, match.Group.Name, machineType)
+					errorMessages = append(errorMessages, message)
+					continue
+				}
+
+				// Report each incompatibility between the image and the type.
+				for _, problem := range instanceTypeIncompatibilities(match.Image, info) {
+					message := fmt.Sprintf("[%s] instance group %s image %s (%s) is incompatible with machine type %s: %s",
+						match.Region, match.Group.Name, match.Reference.String(), aws.StringValue(match.Image.ImageId), machineType, problem)
+					errorMessages = append(errorMessages, message)
+				}
+			}
+
+			// Report zones of the group that do not offer the type.
+			zones := instanceGroupRegionZones(match.Group, match.Region)
+			if len(zones) == 0 || permissions.lacks(describeInstanceTypeOfferingsPermission) {
+				continue
+			}
+			if offerings[match.Region] == nil {
+				offerings[match.Region] = make(map[string][]string)
+			}
+			offered, ok := offerings[match.Region][machineType]
+			if !ok {
+				described, err := describeInstanceTypeOfferings(ctx, clients, match.Region, machineType)
+				if err != nil {
+					if permissions.skip(describeInstanceTypeOfferingsPermission, err) {
+						continue
+					}
+					return nil, nil, err
+				}
+				offered = described
+				offerings[match.Region][machineType] = offered
+			}
+			for _, zone := range zones {
+				if !containsString(offered, zone) {
+					message := fmt.Sprintf("[%s] instance group %s machine type %s is not offered in zone %s", match.Region, match.Group.Name, machineType, zone)
+					errorMessages = append(errorMessages, message)
+				}
+			}
+		}
+	}
+
+	return errorMessages, permissions.warnings, nil
+}
+
+// describeInstanceType fetches an instance type, returning nil when the region does not offer it.
+func describeInstanceType(ctx context.Context, clients *awsClients, region string, machineType string) (*ec2.InstanceTypeInfo, error) {
+	// Query the single instance type so unknown types do not hide the others.
+	log.Debugln("Describing instance type", machineType, "in", region)
+	output, err := clients.ec2Client(region).DescribeInstanceTypesWithContext(ctx, &ec2.DescribeInstanceTypesInput{
+		InstanceTypes: aws.StringSlice([]string{machineType}),
+	})
+	if err != nil {
+		if awsErrorCode(err) == "InvalidInstanceType" {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to describe instance type %s in %s: %w", machineType, region, err)
+	}
+	if len(output.InstanceTypes) == 0 {
+		return nil, nil
+	}
+
+	return output.InstanceTypes[0], nil
+}
+
+// describeInstanceTypeOfferings lists the availability zones of a region that offer an instance type.
+func describeInstanceTypeOfferings(ctx context.Context, clients *awsClients, region string, machineType string) ([]string, error) {
+	// Page through the zone offerings of the type.
+	log.Debugln("Describing instance type offerings for", machineType, "in", region)
+	zones := make([]string, 0)
+	err := clients.ec2Client(region).DescribeInstanceTypeOfferingsPagesWithContext(ctx, &ec2.DescribeInstanceTypeOfferingsInput{
+		LocationType: aws.String(ec2.LocationTypeAvailabilityZone),
+		Filters:      []*ec2.Filter{newEC2Filter("instance-type", machineType)},
+	}, func(page *ec2.DescribeInstanceTypeOfferingsOutput, lastPage bool) bool {
+		for _, offering := range page.InstanceTypeOfferings {
+			if offering != nil {
+				zones = append(zones, aws.StringValue(offering.Location))
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe instance type offerings for %s in %s: %w", machineType, region, err)
+	}
+
+	return zones, nil
+}
+
+// instanceRequirementsTypes lists the instance types of a region that satisfy attribute based requirements for an image.
+func instanceRequirementsTypes(ctx context.Context, clients *awsClients, region string, image *ec2.Image, requirements *kops.InstanceRequirementsSpec) ([]string, error) {
+	// Convert the requirements the same way kops does for the autoscaling group.
+	request := &ec2.InstanceRequirementsRequest{
+		VCpuCount: &ec2.VCpuCountRangeRequest{Min: aws.Int64(0)},
+		MemoryMiB: &ec2.MemoryMiBRequest{Min: aws.Int64(0)},
+	}
+	if requirements.CPU != nil {
+		if requirements.CPU.Min != nil {
+			request.VCpuCount.Min = quantityValue(requirements.CPU.Min, 0)
+		}
+		request.VCpuCount.Max = quantityValue(requirements.CPU.Max, 0)
+	}
+	if requirements.Memory != nil {
+		if requirements.Memory.Min != nil {
+			request.MemoryMiB.Min = quantityValue(requirements.Memory.Min, resource.Mega)
+		}
+		request.MemoryMiB.Max = quantityValue(requirements.Memory.Max, resource.Mega)
+	}
+
+	// Restrict the selection to the image's architecture and virtualization.
+	input := &ec2.GetInstanceTypesFromInstanceRequirementsInput{
+		ArchitectureTypes:    aws.StringSlice([]string{aws.StringValue(image.Architecture)}),
+		VirtualizationTypes:  aws.StringSlice([]string{aws.StringValue(image.VirtualizationType)}),
+		InstanceRequirements: request,
+	}
+	if len(aws.StringValue(image.VirtualizationType)) == 0 {
+		input.VirtualizationTypes = aws.StringSlice([]string{ec2.VirtualizationTypeHvm})
+	}
+
+	// Page through the matching types.
+	log.Debugln("Selecting instance types from requirements in", region)
+	machineTypes := make([]string, 0)
+	err := clients.ec2Client(region).GetInstanceTypesFromInstanceRequirementsPagesWithContext(ctx, input, func(page *ec2.GetInstanceTypesFromInstanceRequirementsOutput, lastPage bool) bool {
+		for _, instanceType := range page.InstanceTypes {
+			if instanceType != nil {
+				machineTypes = append(machineTypes, aws.StringValue(instanceType.InstanceType))
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to select instance types from requirements in %s: %w", region, err)
+	}
+
+	return machineTypes, nil
+}
+
+// quantityValue converts an instance requirement quantity, scaled when a scale is given, returning nil when unset.
+func quantityValue(quantity *resource.Quantity, scale resource.Scale) *int64 {
+	// Leave unset bounds open.
+	if quantity == nil {
+		return nil
+	}
+	if scale == 0 {
+		value, _ := quantity.AsInt64()
+		return aws.Int64(value)
+	}
+
+	return aws.Int64(quantity.ScaledValue(scale))
+}
+
+// instanceTypeIncompatibilities compares an image's architecture, virtualization, boot mode, and ENA support with an instance type.
+func instanceTypeIncompatibilities(image *ec2.Image, info *ec2.InstanceTypeInfo) []string {
+	// Prepare the problem list, which stays empty when the type could not be described.
+	problems := make([]string, 0)
+	if info == nil {
+		return problems
+	}
+
+	// Compare the CPU architecture.
+	architecture := aws.StringValue(image.Architecture)
+	if info.ProcessorInfo != nil && len(architecture) != 0 {
+		supported := aws.StringValueSlice(info.ProcessorInfo.SupportedArchitectures)
+		if !containsString(supported, architecture) {
+			problems = append(problems, fmt.Sprintf("architecture %s is not one of %s", architecture, strings.Join(supported, ", ")))
+		}
+	}
+
+	// Compare the virtualization type.
+	virtualization := aws.StringValue(image.VirtualizationType)
+	supportedVirtualization := aws.StringValueSlice(info.SupportedVirtualizationTypes)
+	if len(virtualization) != 0 && len(supportedVirtualization) != 0 && !containsString(supportedVirtualization, virtualization) {
+		problems = append(problems, fmt.Sprintf("virtualization type %s is not one of %s", virtualization, strings.Join(supportedVirtualization, ", ")))
+	}
+
+	// Compare the boot mode, which defaults by architecture when the image does not set one.
+	bootMode := imageBootMode(image)
+	supportedBootModes := aws.StringValueSlice(info.SupportedBootModes)
+	if len(supportedBootModes) != 0 && bootMode != ec2.BootModeValuesUefiPreferred && !containsString(supportedBootModes, bootMode) {
+		problems = append(problems, fmt.Sprintf("boot mode %s is not one of %s", bootMode, strings.Join(supportedBootModes, ", ")))
+	}
+
+	// Instance types that require ENA cannot boot images without it.
+	if info.NetworkInfo != nil && aws.StringValue(info.NetworkInfo.EnaSupport) == ec2.EnaSupportRequired && !aws.BoolValue(image.EnaSupport) {
+		problems = append(problems, "instance type requires ENA but the image does not enable it")
+	}
+
+	return problems
+}
+
+// imageBootMode returns the boot mode an image launches with.
+func imageBootMode(image *ec2.Image) string {
+	// Use the explicit boot mode when set.
+	bootMode := aws.StringValue(image.BootMode)
+	if len(bootMode) != 0 {
+		return bootMode
+	}
+
+	// Graviton images default to UEFI, everything else to legacy BIOS.
+	if aws.StringValue(image.Architecture) == ec2.ArchitectureValuesArm64 {
+		return ec2.BootModeValuesUefi
+	}
+
+	return ec2.BootModeValuesLegacyBios
+}
+
+// containsString reports whether a value is in a list.
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/kops/pkg/apis/kops"
+)
+
+// fakeInstanceTypeEC2 serves instance type details from memory.
+type fakeInstanceTypeEC2 struct {
+	ec2iface.EC2API
+	instanceTypes map[string]*ec2.InstanceTypeInfo
+	offerings     map[string][]string
+	requirements  []*ec2.GetInstanceTypesFromInstanceRequirementsInput
+}
+
+// DescribeInstanceTypesWithContext returns the stored instance type or an invalid type error.
+func (f *fakeInstanceTypeEC2) DescribeInstanceTypesWithContext(ctx context.Context, input *ec2.DescribeInstanceTypesInput, opts ...request.Option) (*ec2.DescribeInstanceTypesOutput, error) {
+	// Return each requested type.
+	output := &ec2.DescribeInstanceTypesOutput{}
+	for _, name := range aws.StringValueSlice(input.InstanceTypes) {
+		info, ok := f.instanceTypes[name]
+		if !ok {
+			return nil, awserr.New("InvalidInstanceType", "invalid instance type", nil)
+		}
+		output.InstanceTypes = append(output.InstanceTypes, info)
+	}
+
+	return output, nil
+}
+
+// DescribeInstanceTypeOfferingsPagesWithContext returns the stored zones offering the filtered type.
+func (f *fakeInstanceTypeEC2) DescribeInstanceTypeOfferingsPagesWithContext(ctx context.Context, input *ec2.DescribeInstanceTypeOfferingsInput, fn func(*ec2.DescribeInstanceTypeOfferingsOutput, bool) bool, opts ...request.Option) error {
+	// Emit one offering per zone.
+	machineType := aws.StringValue(input.Filters[0].Values[0])
+	page := &ec2.DescribeInstanceTypeOfferingsOutput{}
+	for _, zone := range f.offerings[machineType] {
+		page.InstanceTypeOfferings = append(page.InstanceTypeOfferings, &ec2.InstanceTypeOffering{
+			InstanceType: aws.String(machineType),
+			Location:     aws.String(zone),
+			LocationType: input.LocationType,
+		})
+	}
+	fn(page, true)
+
+	return nil
+}
+
+// GetInstanceTypesFromInstanceRequirementsPagesWithContext returns the stored types of the requested architecture.
+func (f *fakeInstanceTypeEC2) GetInstanceTypesFromInstanceRequirementsPagesWithContext(ctx context.Context, input *ec2.GetInstanceTypesFromInstanceRequirementsInput, fn func(*ec2.GetInstanceTypesFromInstanceRequirementsOutput, bool) bool, opts ...request.Option) error {
+	// Record the request and emit the types supporting the architecture.
+	f.requirements = append(f.requirements, input)
+	page := &ec2.GetInstanceTypesFromInstanceRequirementsOutput{}
+	for name, info := range f.instanceTypes {
+		if containsString(aws.StringValueSlice(info.ProcessorInfo.SupportedArchitectures), aws.StringValue(input.ArchitectureTypes[0])) {
+			page.InstanceTypes = append(page.InstanceTypes, &ec2.InstanceTypeInfoFromInstanceRequirements{InstanceType: aws.String(name)})
+		}
+	}
+	fn(page, true)
+
+	return nil
+}
+
+// buildInstanceTypeInfo describes an instance type for compatibility tests.
+func buildInstanceTypeInfo(name string, architecture string, bootModes []string, ena string) *ec2.InstanceTypeInfo {
+	return &ec2.InstanceTypeInfo{
+		InstanceType:                 aws.String(name),
+		ProcessorInfo:                &ec2.ProcessorInfo{SupportedArchitectures: aws.StringSlice([]string{architecture})},
+		SupportedVirtualizationTypes: aws.StringSlice([]string{ec2.VirtualizationTypeHvm}),
+		SupportedBootModes:           aws.StringSlice(bootModes),
+		NetworkInfo:                  &ec2.NetworkInfo{EnaSupport: aws.String(ena)},
+	}
+}
+
+// TestInstanceTypeIncompatibilities verifies architecture, boot mode, and ENA mismatches are found.
+func TestInstanceTypeIncompatibilities(t *testing.T) {
+	// Build an x86 image without ENA and a Graviton instance type requiring it.
+	image := buildOwnedImage("ami-x86", "099720109477", "x86", "2023-01-01T00:00:00.000Z")
+	image.Architecture = aws.String(ec2.ArchitectureValuesX8664)
+	image.VirtualizationType = aws.String(ec2.VirtualizationTypeHvm)
+	graviton := buildInstanceTypeInfo("m6g.large", ec2.ArchitectureTypeArm64, []string{ec2.BootModeTypeUefi}, ec2.EnaSupportRequired)
+
+	// Expect architecture, boot mode, and ENA problems.
+	problems := instanceTypeIncompatibilities(image, graviton)
+	if len(problems) != 3 {
+		t.Fatalf("expected three problems, got %v", problems)
+	}
+
+	// A matching x86 type is compatible.
+	image.EnaSupport = aws.Bool(true)
+	intel := buildInstanceTypeInfo("m5.large", ec2.ArchitectureTypeX8664, []string{ec2.BootModeTypeLegacyBios, ec2.BootModeTypeUefi}, ec2.EnaSupportRequired)
+	problems = instanceTypeIncompatibilities(image, intel)
+	if len(problems) != 0 {
+		t.Fatalf("expected no problems, got %v", problems)
+	}
+}
+
+// TestCheckInstanceTypeCompatibility verifies each machine type is checked and unknown types are reported.
+func TestCheckInstanceTypeCompatibility(t *testing.T) {
+	// Serve one Graviton instance type.
+	client := &fakeInstanceTypeEC2{instanceTypes: map[string]*ec2.InstanceTypeInfo{
+		"m6g.large": buildInstanceTypeInfo("m6g.large", ec2.ArchitectureTypeArm64, []string{ec2.BootModeTypeUefi}, ec2.EnaSupportRequired),
+	}}
+	image := buildOwnedImage("ami-x86", "099720109477", "x86", "2023-01-01T00:00:00.000Z")
+	image.Architecture = aws.String(ec2.ArchitectureValuesX8664)
+	image.EnaSupport = aws.Bool(true)
+	group := buildInstanceGroup("099720109477/x86")
+	group.Spec.MachineType = "m6g.large,m9z.huge"
+	matches := []*imageMatch{{Group: group, Reference: &imageReference{Raw: "099720109477/x86"}, Image: image, Region: "us-east-1"}}
+
+	// Check compatibility.
+	errorMessages, _, err := checkInstanceTypeCompatibility(context.Background(), buildFakeClients(client, nil), matches)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(errorMessages) != 3 {
+		t.Fatalf("expected architecture, boot mode, and unknown type errors, got %v", errorMessages)
+	}
+	if !strings.Contains(errorMessages[2], "m9z.huge is not offered") {
+		t.Fatalf("expected unknown type to be reported, got %s", errorMessages[2])
+	}
+}
+
+// TestInstanceGroupMachineTypes verifies mixed instance overrides are included once.
+func TestInstanceGroupMachineTypes(t *testing.T) {
+	// Combine the machine type list with the mixed instances policy.
+	group := buildInstanceGroup("099720109477/x86")
+	group.Spec.MachineType = "m5.large, m5a.large"
+	group.Spec.MixedInstancesPolicy = &kops.MixedInstancesPolicySpec{Instances: []string{"m5a.large", "c5.large"}}
+	machineTypes := instanceGroupMachineTypes(group)
+	if strings.Join(machineTypes, ",") != "m5.large,m5a.large,c5.large" {
+		t.Fatalf("unexpected machine types: %v", machineTypes)
+	}
+
+	// Karpenter groups do not use the mixed instances policy.
+	group.Spec.Manager = kops.InstanceManagerKarpenter
+	machineTypes = instanceGroupMachineTypes(group)
+	if len(machineTypes) != 2 {
+		t.Fatalf("expected only the machine types, got %v", machineTypes)
+	}
+}
+
+// TestCheckInstanceTypeCompatibilityMixedInstances verifies zone offerings, instance requirements, and warm pools are checked.
+func TestCheckInstanceTypeCompatibilityMixedInstances(t *testing.T) {
+	// Offer an x86 type in one zone and a Graviton type in both.
+	client := &fakeInstanceTypeEC2{
+		instanceTypes: map[string]*ec2.InstanceTypeInfo{
+			"m5.large":  buildInstanceTypeInfo("m5.large", ec2.ArchitectureTypeX8664, []string{ec2.BootModeTypeLegacyBios}, ec2.EnaSupportSupported),
+			"m6g.large": buildInstanceTypeInfo("m6g.large", ec2.ArchitectureTypeArm64, []string{ec2.BootModeTypeUefi}, ec2.EnaSupportRequired),
+		},
+		offerings: map[string][]string{
+			"m5.large":  {"us-east-1a"},
+			"m6g.large": {"us-east-1a", "us-east-1b"},
+		},
+	}
+	image := buildOwnedImage("ami-arm", "099720109477", "arm", "2023-01-01T00:00:00.000Z")
+	image.Architecture = aws.String(ec2.ArchitectureValuesArm64)
+	image.EnaSupport = aws.Bool(true)
+	image.RootDeviceType = aws.String(ec2.DeviceTypeInstanceStore)
+
+	// Build a group mixing architectures with a warm pool and CPU requirements.
+	group := buildInstanceGroup("099720109477/arm")
+	group.Spec.MachineType = "m6g.large"
+	group.Spec.Zones = []string{"us-east-1a", "us-east-1b", "us-west-2a"}
+	group.Spec.WarmPool = &kops.WarmPoolSpec{}
+	minCPU := resource.MustParse("2")
+	group.Spec.MixedInstancesPolicy = &kops.MixedInstancesPolicySpec{
+		Instances:            []string{"m5.large"},
+		InstanceRequirements: &kops.InstanceRequirementsSpec{CPU: &kops.MinMaxSpec{Min: &minCPU}},
+	}
+	matches := []*imageMatch{{Group: group, Reference: &imageReference{Raw: "099720109477/arm"}, Image: image, Region: "us-east-1"}}
+
+	// Check compatibility.
+	errorMessages, _, err := checkInstanceTypeCompatibility(context.Background(), buildFakeClients(client, nil), matches)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"has a warm pool", "m5.large", "m5.large", "m5.large is not offered in zone us-east-1b"}
+	if len(errorMessages) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), errorMessages)
+	}
+	for i, fragment := range expected {
+		if !strings.Contains(errorMessages[i], fragment) {
+			t.Fatalf("expected error %d to contain %q, got %s", i, fragment, errorMessages[i])
+		}
+	}
+	if len(client.requirements) != 1 || aws.Int64Value(client.requirements[0].InstanceRequirements.VCpuCount.Min) != 2 {
+		t.Fatalf("expected a requirements query with two vCPUs, got %v", client.requirements)
+	}
+}
+
+// deniedInstanceTypeEC2 refuses every instance type request.
+type deniedInstanceTypeEC2 struct {
+	ec2iface.EC2API
+}
+
+// DescribeInstanceTypesWithContext refuses the request.
+func (f *deniedInstanceTypeEC2) DescribeInstanceTypesWithContext(ctx context.Context, input *ec2.DescribeInstanceTypesInput, opts ...request.Option) (*ec2.DescribeInstanceTypesOutput, error) {
+	return nil, awserr.New("UnauthorizedOperation", "not authorized", nil)
+}
+
+// DescribeInstanceTypeOfferingsPagesWithContext refuses the request.
+func (f *deniedInstanceTypeEC2) DescribeInstanceTypeOfferingsPagesWithContext(ctx context.Context, input *ec2.DescribeInstanceTypeOfferingsInput, fn func(*ec2.DescribeInstanceTypeOfferingsOutput, bool) bool, opts ...request.Option) error {
+	return awserr.New("UnauthorizedOperation", "not authorized", nil)
+}
+
+// TestCheckInstanceTypeCompatibilityUnauthorized verifies missing permissions skip their checks with one warning each.
+func TestCheckInstanceTypeCompatibilityUnauthorized(t *testing.T) {
+	// Check two zonal machine types with a role that may not describe them.
+	image := buildOwnedImage("ami-x86", "099720109477", "x86", "2023-01-01T00:00:00.000Z")
+	group := buildInstanceGroup("099720109477/x86")
+	group.Spec.MachineType = "m5.large,m5.xlarge"
+	group.Spec.Zones = []string{"us-east-1a"}
+	matches := []*imageMatch{{Group: group, Reference: &imageReference{Raw: "099720109477/x86"}, Image: image, Region: "us-east-1"}}
+
+	// The refusals are warnings rather than an error.
+	errorMessages, warnings, err := checkInstanceTypeCompatibility(context.Background(), buildFakeClients(&deniedInstanceTypeEC2{}, nil), matches)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(errorMessages) != 0 {
+		t.Fatalf("expected no failures, got %v", errorMessages)
+	}
+	if len(warnings) != 2 || !strings.Contains(warnings[0], describeInstanceTypesPermission) || !strings.Contains(warnings[1], describeInstanceTypeOfferingsPermission) {
+		t.Fatalf("expected one warning per permission, got %v", warnings)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// launchPermissionGroupAll is the launch permission group of public images.
+	launchPermissionGroupAll = "all"
+)
+
+// checkLaunchPermissions reports matched images that are not launchable by the cluster account.
+func checkLaunchPermissions(ctx context.Context, clients *awsClients, matches []*imageMatch, clusterAccount string, callerAccount string) []string {
+	// Prepare the error list.
+	errorMessages := make([]string, 0)
+	log.Infoln("Checking AMI launch permissions for account", clusterAccount)
+
+	// Inspect each matched image.
+	for _, match := range matches {
+		err := verifyLaunchPermission(ctx, clients.ec2Client(match.Region), match.Image, clusterAccount, callerAccount)
+		if err != nil {
+			message := fmt.Sprintf("[%s] instance group %s image %s (%s): %s",
+				match.Region, match.Group.Name, match.Reference.String(), aws.StringValue(match.Image.ImageId), err.Error())
+			errorMessages = append(errorMessages, message)
+		}
+	}
+
+	return errorMessages
+}
+
+// verifyLaunchPermission confirms an image is public, owned by, or shared with the cluster account.
+func verifyLaunchPermission(ctx context.Context, ec2Client ec2iface.EC2API, image *ec2.Image, clusterAccount string, callerAccount string) error {
+	// Public and cluster owned images are always launchable.
+	imageID := aws.StringValue(image.ImageId)
+	if aws.BoolValue(image.Public) {
+		return nil
+	}
+	if aws.StringValue(image.OwnerId) == clusterAccount {
+		return nil
+	}
+
+	// Read the launch permissions, which only the image owner may do.
+	output, err := ec2Client.DescribeImageAttributeWithContext(ctx, &ec2.DescribeImageAttributeInput{
+		ImageId:   aws.String(imageID),
+		Attribute: aws.String(ec2.ImageAttributeNameLaunchPermission),
+	})
+	if err != nil {
+		// A private image owned elsewhere is only visible to accounts it is shared with, so the owner check failing proves the share.
+		code := awsErrorCode(err)
+		if callerAccount == clusterAccount && (code == "AuthFailure" || code == "UnauthorizedOperation") {
+			log.Debugf("Image %s is visible to account %s, so it is shared with it: %s", imageID, clusterAccount, err.Error())
+			return nil
+		}
+		return fmt.Errorf("unable to verify launch permission for account %s: %w", clusterAccount, err)
+	}
+
+	// Look for a permission covering the cluster account.
+	for _, permission := range output.LaunchPermissions {
+		if permission == nil {
+			continue
+		}
+		if aws.StringValue(permission.Group) == launchPermissionGroupAll {
+			return nil
+		}
+		if aws.StringValue(permission.UserId) == clusterAccount {
+			return nil
+		}
+		if permission.OrganizationArn != nil || permission.OrganizationalUnitArn != nil {
+			log.Infof("Image %s is shared with an organization; membership of account %s is not verified.", imageID, clusterAccount)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("image is owned by %s and is not shared with account %s", aws.StringValue(image.OwnerId), clusterAccount)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// fakeLaunchPermissionEC2 serves launch permissions for owned images.
+type fakeLaunchPermissionEC2 struct {
+	ec2iface.EC2API
+	permissions map[string][]*ec2.LaunchPermission
+	// err fails every request when set.
+	err error
+}
+
+// DescribeImageAttributeWithContext returns stored launch permissions or an authorization failure.
+func (f *fakeLaunchPermissionEC2) DescribeImageAttributeWithContext(ctx context.Context, input *ec2.DescribeImageAttributeInput, opts ...request.Option) (*ec2.DescribeImageAttributeOutput, error) {
+	// Fail every request when configured.
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	// Only owned images expose their permissions.
+	permissions, ok := f.permissions[aws.StringValue(input.ImageId)]
+	if !ok {
+		return nil, awserr.New("AuthFailure", "not authorized", nil)
+	}
+
+	return &ec2.DescribeImageAttributeOutput{LaunchPermissions: permissions}, nil
+}
+
+// TestVerifyLaunchPermission verifies public, owned, shared, and unshared images.
+func TestVerifyLaunchPermission(t *testing.T) {
+	// Describe the permissions of images owned by the caller.
+	client := &fakeLaunchPermissionEC2{permissions: map[string][]*ec2.LaunchPermission{
+		"ami-shared":   {{UserId: aws.String("222222222222")}},
+		"ami-unshared": {{UserId: aws.String("333333333333")}},
+	}}
+	public := buildOwnedImage("ami-public", "099720109477", "public", "2023-01-01T00:00:00.000Z")
+	public.Public = aws.Bool(true)
+	owned := buildOwnedImage("ami-owned", "222222222222", "owned", "2023-01-01T00:00:00.000Z")
+	shared := buildOwnedImage("ami-shared", "111111111111", "shared", "2023-01-01T00:00:00.000Z")
+	unshared := buildOwnedImage("ami-unshared", "111111111111", "unshared", "2023-01-01T00:00:00.000Z")
+	foreign := buildOwnedImage("ami-foreign", "444444444444", "foreign", "2023-01-01T00:00:00.000Z")
+
+	// The caller owns the images and checks a different cluster account.
+	ctx := context.Background()
+	for _, image := range []*ec2.Image{public, owned, shared} {
+		err := verifyLaunchPermission(ctx, client, image, "222222222222", "111111111111")
+		if err != nil {
+			t.Fatalf("expected %s to be launchable: %v", aws.StringValue(image.ImageId), err)
+		}
+	}
+	err := verifyLaunchPermission(ctx, client, unshared, "222222222222", "111111111111")
+	if err == nil {
+		t.Fatalf("expected unshared image to be reported")
+	}
+	err = verifyLaunchPermission(ctx, client, foreign, "222222222222", "111111111111")
+	if err == nil {
+		t.Fatalf("expected unverifiable image to be reported")
+	}
+
+	// A visible foreign image is shared with the caller when the caller is the cluster account.
+	err = verifyLaunchPermission(ctx, client, foreign, "222222222222", "222222222222")
+	if err != nil {
+		t.Fatalf("expected visible image to be launchable: %v", err)
+	}
+
+	// Other errors are not proof of a share.
+	client.err = awserr.New("RequestLimitExceeded", "request limit exceeded", nil)
+	err = verifyLaunchPermission(ctx, client, foreign, "222222222222", "222222222222")
+	if err == nil {
+		t.Fatalf("expected throttling to be reported")
+	}
+}
@@ -36,7 +36,8 @@ func main() {
 	}
 
 	// Build the AWS session for the check.
-	awsSession, err := createAWSSession()
+	retries := &retryStats{}
+	awsSession, err := createAWSSession(newAWSRetryer(cfg, retries))
 	if err != nil {
 		reportFailure([]string{err.Error()})
 		return
@@ -46,13 +47,22 @@ func main() {
 	defer recoverAndReport()
 
 	// Run the main AMI check logic.
-	err = runCheck(cfg, awsSession)
+	report, err := runCheck(ctx, cfg, newAWSClients(awsSession, newRateLimiter(cfg)))
+
+	// Record the AWS retries of the run, and any error alongside the findings gathered before it.
+	report.warn(retries.summary()...)
 	if err != nil {
-		reportFailure([]string{err.Error()})
+		report.fail(err.Error())
+	}
+
+	// Report failures along with any warnings.
+	if report.failed() {
+		reportFailure(report.messages())
 		return
 	}
 
-	// Report success if no errors were found.
+	// Report success if no failures were found.
+	report.logWarnings()
 	reportSuccess()
 }
 
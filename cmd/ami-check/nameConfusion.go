@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxLookalikeImages caps how many look-alike images are listed per reference.
+	maxLookalikeImages = 5
+)
+
+// checkImageOwnerMismatch reports name references resolved to an image that is not owned by the referenced owner.
+func checkImageOwnerMismatch(matches []*imageMatch) []string {
+	// Prepare the error list.
+	errorMessages := make([]string, 0)
+
+	// Inspect each name reference.
+	for _, match := range matches {
+		if len(match.Reference.ImageID) != 0 || len(match.Reference.Owner) == 0 {
+			continue
+		}
+		if imageMatchesOwner(match.Image, match.Reference.Owner) {
+			continue
+		}
+		message := fmt.Sprintf("[%s] instance group %s image %s resolved to %s (%s) owned by %s, not the expected owner %s",
+			match.Region, match.Group.Name, match.Reference.String(), aws.StringValue(match.Image.ImageId), aws.StringValue(match.Image.Name),
+			aws.StringValue(match.Image.OwnerId), match.Reference.Owner)
+		errorMessages = append(errorMessages, message)
+	}
+
+	return errorMessages
+}
+
+// checkLookalikeImages reports public images from untrusted owners whose names resemble a name reference.
+func checkLookalikeImages(ctx context.Context, clients *awsClients, matches []*imageMatch, trusted *trustedOwners) ([]string, error) {
+	// Prepare the warning list and per-region name cache.
+	warnings := make([]string, 0)
+	searched := make(map[string][]*ec2.Image)
+	log.Infoln("Searching for look-alike AMIs from untrusted owners.")
+
+	// Inspect each name reference.
+	for _, match := range matches {
+		if len(match.Reference.ImageID) != 0 || len(match.Reference.Name) == 0 {
+			continue
+		}
+
+		// Search public images with a similar name once per region.
+		cacheKey := match.Region + "|" + match.Reference.Name
+		images, ok := searched[cacheKey]
+		if !ok {
+			found, err := describeLookalikeImages(ctx, clients.ec2Client(match.Region), match.Reference.Name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to search look-alike images of %s in %s: %w", match.Reference.Name, match.Region, err)
+			}
+			images = found
+			searched[cacheKey] = images
+		}
+
+		// Keep the images from owners other than the resolved owner and the allowlist.
+		lookalikes := make([]string, 0)
+		for _, image := range images {
+			if aws.StringValue(image.OwnerId) == aws.StringValue(match.Image.OwnerId) {
+				continue
+			}
+			if trusted != nil && trusted.allows(image) {
+				continue
+			}
+			lookalikes = append(lookalikes, fmt.Sprintf("%s (%s) owned by %s", aws.StringValue(image.ImageId), aws.StringValue(image.Name), aws.StringValue(image.OwnerId)))
+		}
+		if len(lookalikes) == 0 {
+			continue
+		}
+		sort.Strings(lookalikes)
+		message := fmt.Sprintf("[%s] instance group %s image %s has public look-alikes from untrusted owners: %s",
+			match.Region, match.Group.Name, match.Reference.String(), strings.Join(lookalikes, "; "))
+		warnings = append(warnings, message)
+	}
+
+	return warnings, nil
+}
+
+// describeLookalikeImages lists one public image per owner whose name contains a reference name, stopping once more than maxLookalikeImages owners are found.
+func describeLookalikeImages(ctx context.Context, ec2Client ec2iface.EC2API, name string) ([]*ec2.Image, error) {
+	// Search every public image containing the name.
+	input := &ec2.DescribeImagesInput{
+		Filters: []*ec2.Filter{
+			newEC2Filter("name", "*"+strings.Trim(name, "*")+"*"),
+			newEC2Filter("is-public", "true"),
+		},
+		MaxResults: aws.Int64(describeImagesPageSize),
+	}
+
+	// Keep one image per owner, stopping once enough owners are known.
+	byOwner := make(map[string]*ec2.Image)
+	err := ec2Client.DescribeImagesPagesWithContext(ctx, input, func(page *ec2.DescribeImagesOutput, lastPage bool) bool {
+		for _, image := range page.Images {
+			if image == nil {
+				continue
+			}
+			_, ok := byOwner[aws.StringValue(image.OwnerId)]
+			if !ok {
+				byOwner[aws.StringValue(image.OwnerId)] = image
+			}
+		}
+		return len(byOwner) <= maxLookalikeImages
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Return the images in a stable order.
+	owners := make([]string, 0, len(byOwner))
+	for owner := range byOwner {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+	images := make([]*ec2.Image, 0, len(owners))
+	for _, owner := range owners {
+		images = append(images, byOwner[owner])
+	}
+
+	return images, nil
+}
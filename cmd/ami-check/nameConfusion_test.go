@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// TestCheckImageOwnerMismatch verifies name references resolved under another owner fail.
+func TestCheckImageOwnerMismatch(t *testing.T) {
+	// Resolve one reference to the expected owner and one to an impostor.
+	genuine := buildOwnedImage("ami-genuine", "099720109477", "ubuntu-focal", "2023-01-01T00:00:00.000Z")
+	impostor := buildOwnedImage("ami-impostor", "222222222222", "ubuntu-focal", "2023-01-01T00:00:00.000Z")
+	ref := &imageReference{Raw: "099720109477/ubuntu-focal", Owner: "099720109477", Name: "ubuntu-focal"}
+	matches := []*imageMatch{
+		{Group: buildInstanceGroup(ref.Raw), Reference: ref, Image: genuine, Region: "us-east-1"},
+		{Group: buildInstanceGroup(ref.Raw), Reference: ref, Image: impostor, Region: "us-west-2"},
+		{Group: buildInstanceGroup("ami-impostor"), Reference: &imageReference{Raw: "ami-impostor", ImageID: "ami-impostor"}, Image: impostor, Region: "us-east-1"},
+	}
+
+	// Only the name reference resolved to the impostor fails.
+	errorMessages := checkImageOwnerMismatch(matches)
+	if len(errorMessages) != 1 || !strings.Contains(errorMessages[0], "[us-west-2]") || !strings.Contains(errorMessages[0], "owned by 222222222222") {
+		t.Fatalf("expected one owner mismatch, got %v", errorMessages)
+	}
+}
+
+// TestCheckLookalikeImages verifies public look-alikes from untrusted owners are reported.
+func TestCheckLookalikeImages(t *testing.T) {
+	// Publish the genuine image, a trusted mirror, a look-alike, and a private copy.
+	genuine := buildOwnedImage("ami-genuine", "099720109477", "ubuntu-focal", "2023-01-01T00:00:00.000Z")
+	mirror := buildOwnedImage("ami-mirror", "136693071363", "ubuntu-focal-mirror", "2023-01-01T00:00:00.000Z")
+	lookalike := buildOwnedImage("ami-lookalike", "222222222222", "ubuntu-focal-hardened", "2023-01-01T00:00:00.000Z")
+	private := buildOwnedImage("ami-private", "333333333333", "ubuntu-focal", "2023-01-01T00:00:00.000Z")
+	for _, image := range []*ec2.Image{genuine, mirror, lookalike} {
+		image.Public = aws.Bool(true)
+	}
+	client := &fakeEC2{images: []*ec2.Image{genuine, mirror, lookalike, private}}
+	ref := &imageReference{Raw: "099720109477/ubuntu-focal", Owner: "099720109477", Name: "ubuntu-focal"}
+	matches := []*imageMatch{{Group: buildInstanceGroup(ref.Raw), Reference: ref, Image: genuine, Region: "us-east-1"}}
+	trusted := newTrustedOwners([]string{"136693071363"}, "")
+
+	// Expect only the untrusted public look-alike.
+	warnings, err := checkLookalikeImages(context.Background(), buildFakeClients(client, nil), matches, trusted)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "ami-lookalike (ubuntu-focal-hardened) owned by 222222222222") {
+		t.Fatalf("expected the look-alike to be reported, got %v", warnings)
+	}
+	if strings.Contains(warnings[0], "ami-mirror") || strings.Contains(warnings[0], "ami-private") {
+		t.Fatalf("expected trusted and private images to be ignored, got %s", warnings[0])
+	}
+}
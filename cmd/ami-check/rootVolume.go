@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"k8s.io/kops/pkg/apis/kops"
+	"k8s.io/kops/pkg/model/defaults"
+)
+
+const (
+	// defaultRootVolumeType is the root volume type kops uses when the instance group does not set one.
+	defaultRootVolumeType = ec2.VolumeTypeGp3
+)
+
+// rootVolumeIOPSLimit describes the provisioned IOPS range of an EBS volume type.
+type rootVolumeIOPSLimit struct {
+	// Min is the lowest IOPS the type accepts.
+	Min int32
+	// Max is the highest IOPS the type accepts.
+	Max int32
+	// PerGiB is the highest IOPS per GiB of volume size.
+	PerGiB int32
+}
+
+// rootVolumeIOPSLimits lists the volume types that accept provisioned IOPS.
+var rootVolumeIOPSLimits = map[string]rootVolumeIOPSLimit{
+	ec2.VolumeTypeGp3: {Min: 3000, Max: 16000, PerGiB: 500},
+	ec2.VolumeTypeIo1: {Min: 100, Max: 64000, PerGiB: 50},
+	ec2.VolumeTypeIo2: {Min: 100, Max: 256000, PerGiB: 1000},
+}
+
+// bootableRootVolumeTypes lists the EBS volume types an instance can boot from.
+var bootableRootVolumeTypes = []string{
+	ec2.VolumeTypeStandard,
+	ec2.VolumeTypeGp2,
+	ec2.VolumeTypeGp3,
+	ec2.VolumeTypeIo1,
+	ec2.VolumeTypeIo2,
+}
+
+// checkRootVolumes reports instance groups whose root volume settings cannot launch their matched image.
+func checkRootVolumes(matches []*imageMatch) []string {
+	// Prepare the error list.
+	errorMessages := make([]string, 0)
+
+	// Compare each group's root volume with its image.
+	for _, match := range matches {
+		for _, problem := range rootVolumeProblems(match.Group, match.Image) {
+			message := fmt.Sprintf("[%s] instance group %s root volume is incompatible with image %s (%s): %s",
+				match.Region, match.Group.Name, match.Reference.String(), aws.StringValue(match.Image.ImageId), problem)
+			errorMessages = append(errorMessages, message)
+		}
+	}
+
+	return errorMessages
+}
+
+// rootVolumeProblems compares an instance group's root volume spec, with kops defaults applied, to an image's root device.
+func rootVolumeProblems(group *kops.InstanceGroup, image *ec2.Image) []string {
+	// Prepare the problem list.
+	problems := make([]string, 0)
+
+	// Instance store images do not launch with an EBS root volume.
+	if aws.StringValue(image.RootDeviceType) != ec2.DeviceTypeEbs {
+		return problems
+	}
+
+	// Apply the same defaults kops uses when building the launch template.
+	size, err := defaults.DefaultInstanceGroupVolumeSize(group.Spec.Role)
+	if err != nil {
+		size = defaults.DefaultVolumeSizeNode
+	}
+	volumeType := defaultRootVolumeType
+	var iops int32
+	rootVolume := group.Spec.RootVolume
+	if rootVolume != nil {
+		if aws.Int32Value(rootVolume.Size) > 0 {
+			size = aws.Int32Value(rootVolume.Size)
+		}
+		if len(aws.StringValue(rootVolume.Type)) != 0 {
+			volumeType = aws.StringValue(rootVolume.Type)
+		}
+		iops = aws.Int32Value(rootVolume.IOPS)
+	}
+
+	// The volume type must be bootable.
+	if !containsString(bootableRootVolumeTypes, volumeType) {
+		problems = append(problems, fmt.Sprintf("volume type %s cannot be used as a root volume", volumeType))
+	}
+
+	// The volume must be at least as large as the root snapshot.
+	rootDevice := imageRootDevice(image)
+	if rootDevice != nil && rootDevice.Ebs != nil {
+		snapshotSize := aws.Int64Value(rootDevice.Ebs.VolumeSize)
+		if int64(size) < snapshotSize {
+			problems = append(problems, fmt.Sprintf("size %dGiB is smaller than the %dGiB root snapshot", size, snapshotSize))
+		}
+	}
+
+	// Provisioned IOPS must fit the volume type and size.
+	limit, ok := rootVolumeIOPSLimits[volumeType]
+	if ok && iops > 0 {
+		if iops < limit.Min || iops > limit.Max {
+			problems = append(problems, fmt.Sprintf("%d IOPS is outside the %d-%d range of %s", iops, limit.Min, limit.Max, volumeType))
+		} else if iops > size*limit.PerGiB {
+			problems = append(problems, fmt.Sprintf("%d IOPS exceeds %d IOPS per GiB for a %dGiB %s volume", iops, limit.PerGiB, size, volumeType))
+		}
+	}
+	if (volumeType == ec2.VolumeTypeIo1 || volumeType == ec2.VolumeTypeIo2) && iops == 0 {
+		problems = append(problems, fmt.Sprintf("volume type %s requires provisioned IOPS", volumeType))
+	}
+
+	// An encrypted root snapshot cannot be restored to an unencrypted volume.
+	if rootVolume != nil && rootVolume.Encryption != nil && !aws.BoolValue(rootVolume.Encryption) &&
+		rootDevice != nil && rootDevice.Ebs != nil && aws.BoolValue(rootDevice.Ebs.Encrypted) {
+		problems = append(problems, "encryption is disabled but the root snapshot is encrypted")
+	}
+
+	return problems
+}
+
+// imageRootDevice returns the block device mapping of an image's root device, or nil.
+func imageRootDevice(image *ec2.Image) *ec2.BlockDeviceMapping {
+	// Find the mapping named by the root device.
+	rootDeviceName := aws.StringValue(image.RootDeviceName)
+	for _, mapping := range image.BlockDeviceMappings {
+		if mapping != nil && aws.StringValue(mapping.DeviceName) == rootDeviceName {
+			return mapping
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"k8s.io/kops/pkg/apis/kops"
+)
+
+// buildRootVolumeImage builds an EBS backed image with a root snapshot of the given size.
+func buildRootVolumeImage(size int64, encrypted bool) *ec2.Image {
+	image := buildOwnedImage("ami-root", "099720109477", "root", "2023-01-01T00:00:00.000Z")
+	image.RootDeviceType = aws.String(ec2.DeviceTypeEbs)
+	image.RootDeviceName = aws.String("/dev/sda1")
+	image.BlockDeviceMappings = []*ec2.BlockDeviceMapping{{
+		DeviceName: aws.String("/dev/sda1"),
+		Ebs:        &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-root"), VolumeSize: aws.Int64(size), Encrypted: aws.Bool(encrypted)},
+	}}
+
+	return image
+}
+
+// TestRootVolumeProblemsDefaults verifies kops default sizes are compared with the root snapshot.
+func TestRootVolumeProblemsDefaults(t *testing.T) {
+	// A node without a root volume spec gets the 128GiB kops default.
+	group := buildInstanceGroup("099720109477/root")
+	group.Spec.Role = kops.InstanceGroupRoleNode
+	problems := rootVolumeProblems(group, buildRootVolumeImage(100, false))
+	if len(problems) != 0 {
+		t.Fatalf("expected no problems, got %v", problems)
+	}
+
+	// A bastion gets 32GiB, which is too small for the snapshot.
+	group.Spec.Role = kops.InstanceGroupRoleBastion
+	problems = rootVolumeProblems(group, buildRootVolumeImage(100, false))
+	if len(problems) != 1 || !strings.Contains(problems[0], "size 32GiB is smaller than the 100GiB root snapshot") {
+		t.Fatalf("expected a size problem, got %v", problems)
+	}
+}
+
+// TestRootVolumeProblemsSpec verifies explicit type, IOPS, and encryption settings are validated.
+func TestRootVolumeProblemsSpec(t *testing.T) {
+	// Configure an undersized io1 volume without IOPS that disables encryption.
+	group := buildInstanceGroup("099720109477/root")
+	group.Spec.Role = kops.InstanceGroupRoleNode
+	group.Spec.RootVolume = &kops.InstanceRootVolumeSpec{
+		Size:       aws.Int32(8),
+		Type:       aws.String(ec2.VolumeTypeIo1),
+		Encryption: aws.Bool(false),
+	}
+	problems := rootVolumeProblems(group, buildRootVolumeImage(20, true))
+	if len(problems) != 3 {
+		t.Fatalf("expected size, IOPS, and encryption problems, got %v", problems)
+	}
+
+	// Provisioned IOPS must fit the size ratio.
+	group.Spec.RootVolume = &kops.InstanceRootVolumeSpec{Size: aws.Int32(20), Type: aws.String(ec2.VolumeTypeGp3), IOPS: aws.Int32(12000)}
+	problems = rootVolumeProblems(group, buildRootVolumeImage(20, true))
+	if len(problems) != 1 || !strings.Contains(problems[0], "exceeds 500 IOPS per GiB") {
+		t.Fatalf("expected an IOPS ratio problem, got %v", problems)
+	}
+
+	// Throughput optimized volumes cannot boot.
+	group.Spec.RootVolume = &kops.InstanceRootVolumeSpec{Size: aws.Int32(200), Type: aws.String(ec2.VolumeTypeSt1)}
+	problems = rootVolumeProblems(group, buildRootVolumeImage(20, false))
+	if len(problems) != 1 || !strings.Contains(problems[0], "cannot be used as a root volume") {
+		t.Fatalf("expected a volume type problem, got %v", problems)
+	}
+}
+
+// TestRootVolumeProblemsVersionedSpec verifies root volume settings stored as v1alpha2 fields are validated rather than the kops defaults.
+func TestRootVolumeProblemsVersionedSpec(t *testing.T) {
+	// Decode a node with a small unencrypted root volume, as kops stores it.
+	group, err := decodeKopsInstanceGroup([]byte(buildInstanceGroupYAML("nodes", "099720109477/root") +
+		"  rootVolumeSize: 20\n  rootVolumeType: gp3\n  rootVolumeEncryption: false\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The configured size and encryption are compared with the image, not the 128GiB encrypted default.
+	problems := rootVolumeProblems(group, buildRootVolumeImage(30, true))
+	if len(problems) != 2 || !strings.Contains(problems[0], "size 20GiB is smaller than the 30GiB root snapshot") {
+		t.Fatalf("expected size and encryption problems, got %v", problems)
+	}
+}
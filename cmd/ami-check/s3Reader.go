@@ -1,154 +1,323 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	log "github.com/sirupsen/logrus"
 	"k8s.io/kops/pkg/apis/kops"
+	"k8s.io/kops/pkg/kopscodecs"
 )
 
+// loadKopsCluster loads the cluster spec from the kops state store in S3.
+func loadKopsCluster(ctx context.Context, cfg *CheckConfig, clients *awsClients) (*kops.Cluster, *stateStoreObjectError, error) {
+	// Log the retrieval intent.
+	key := cfg.ClusterName + kopsStateStoreClusterKey
+	log.Infoln("Reading KOPS cluster spec from AWS S3 key:", key)
+
+	// Request the object from S3.
+	output, err := clients.s3Client(cfg.StateStoreRegion).GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Key:    aws.String(key),
+		Bucket: aws.String(cfg.AWSS3BucketName),
+	})
+	if err != nil {
+		if awsErrorCode(err) == s3.ErrCodeNoSuchKey {
+			return nil, newStateStoreObjectError(key, fmt.Errorf("cluster spec does not exist")), nil
+		}
+		log.Errorf("failed to fetch bucket object with key %s: %s", key, err.Error())
+		return nil, nil, err
+	}
+
+	// Read and decode the cluster spec.
+	objectBytes, objectErr := readStateStoreObject(key, output)
+	if objectErr != nil {
+		return nil, objectErr, nil
+	}
+	cluster, err := decodeKopsCluster(objectBytes)
+	if err != nil {
+		return nil, newStateStoreObjectError(key, err), nil
+	}
+
+	log.Infoln("Found and decoded cluster spec for:", cluster.Name)
+	return cluster, nil, nil
+}
+
 // listKopsInstanceGroups loads instance group data from the kops state store in S3.
-func listKopsInstanceGroups(cfg *CheckConfig, awsSession *session.Session) ([]*kops.InstanceGroup, error) {
+func listKopsInstanceGroups(ctx context.Context, cfg *CheckConfig, clients *awsClients) ([]*kops.InstanceGroup, []*stateStoreObjectError, error) {
 	// Log the retrieval intent.
 	log.Infoln("Listing KOPS instance groups from AWS S3.")
 
-	// Build the S3 client.
-	awsS3 := s3.New(awsSession, &aws.Config{Region: aws.String(cfg.AWSRegion)})
+	// Build the S3 client for the state store region.
+	awsS3 := clients.s3Client(cfg.StateStoreRegion)
 	if awsS3 == nil {
-		return nil, fmt.Errorf("nil S3 client")
+		return nil, nil, fmt.Errorf("nil S3 client")
 	}
 
 	// List object metadata from the bucket.
-	objects, err := listS3Objects(cfg, awsS3)
+	objects, err := listS3Objects(ctx, cfg, awsS3)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Read and parse instance group objects.
-	instanceGroups, err := readInstanceGroupObjects(cfg, awsS3, objects)
+	instanceGroups, objectErrors, err := readInstanceGroupObjects(ctx, cfg, awsS3, objects)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	log.Infoln("Found", len(instanceGroups), "instance groups.")
-	return instanceGroups, nil
+	log.Infoln("Found", len(instanceGroups), "instance groups and", len(objectErrors), "unreadable objects.")
+	return instanceGroups, objectErrors, nil
+}
+
+// instanceGroupPrefix returns the state store key prefix holding the cluster's instance groups.
+func instanceGroupPrefix(cfg *CheckConfig) string {
+	return cfg.ClusterName + kopsStateStoreInstanceGroupKey
 }
 
-// listS3Objects lists objects in the configured S3 bucket.
-func listS3Objects(cfg *CheckConfig, awsS3 *s3.S3) ([]*s3.Object, error) {
-	// Track pagination and results.
-	var marker string
+// listS3Objects lists the instance group objects of the configured cluster.
+func listS3Objects(ctx context.Context, cfg *CheckConfig, awsS3 s3iface.S3API) ([]*s3.Object, error) {
+	// Scope the listing to the cluster's instance groups.
+	prefix := instanceGroupPrefix(cfg)
 	results := make([]*s3.Object, 0)
-	log.Infoln("Querying object keys from S3 bucket.")
+	log.Infoln("Querying object keys from S3 bucket with prefix:", prefix)
 
-	// Fetch the first page of objects.
-	objects, err := awsS3.ListObjects(&s3.ListObjectsInput{
+	// Walk every page of the listing.
+	pages := 0
+	err := awsS3.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
 		Bucket: aws.String(cfg.AWSS3BucketName),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		pages++
+		results = append(results, page.Contents...)
+		if aws.BoolValue(page.IsTruncated) {
+			log.Infoln("There are more bucket objects to be queried after page", pages)
+		}
+		return true
 	})
 	if err != nil {
 		log.Errorln("failed to list bucket objects:", err.Error())
 		return results, err
 	}
-	results = append(results, objects.Contents...)
-
-	// Iterate remaining pages using marker.
-	for objects.Marker != nil {
-		marker = *objects.Marker
-		if len(marker) == 0 {
-			break
-		}
 
-		log.Infoln("There are more bucket objects to be queried:", marker)
+	log.Infoln("Found", len(results), "objects under", prefix, "in", pages, "pages.")
+	return results, nil
+}
 
-		objects, err = awsS3.ListObjects(&s3.ListObjectsInput{
-			Bucket: aws.String(cfg.AWSS3BucketName),
-			Marker: aws.String(marker),
-		})
-		if err != nil {
-			log.Errorln("failed to list bucket objects:", err.Error())
-			return results, err
-		}
+// parseInstanceGroupKey splits a <cluster>/instancegroup/<name> key into its cluster and name.
+func parseInstanceGroupKey(key string) (string, string, bool) {
+	// Require exactly three non-empty segments.
+	parts := strings.Split(key, "/")
+	if len(parts) != 3 {
+		return "", "", false
+	}
+	if len(parts[0]) == 0 || len(parts[2]) == 0 {
+		return "", "", false
+	}
 
-		results = append(results, objects.Contents...)
+	// Require the instance group segment.
+	if parts[1] != strings.Trim(kopsStateStoreInstanceGroupKey, "/") {
+		return "", "", false
 	}
 
-	log.Infoln("Found", len(results), "objects in this bucket.")
-	return results, nil
+	return parts[0], parts[2], true
+}
+
+// stateStoreObjectError records a state store object that could not be read or parsed.
+type stateStoreObjectError struct {
+	// Key is the S3 object key.
+	Key string
+	// Err is the underlying read or parse failure.
+	Err error
 }
 
-// readInstanceGroupObjects loads instance group YAML from S3 and parses it.
-func readInstanceGroupObjects(cfg *CheckConfig, awsS3 *s3.S3, objects []*s3.Object) ([]*kops.InstanceGroup, error) {
-	// Prepare the result slice.
-	results := make([]*kops.InstanceGroup, 0)
-	log.Infoln("Reading S3 object contents.")
+// Error renders the object error for the Kuberhealthy report.
+func (e *stateStoreObjectError) Error() string {
+	return fmt.Sprintf("state store object s3 key %s: %s", e.Key, e.Err.Error())
+}
 
-	// Compile the instance group key matcher.
-	matcher, err := regexp.Compile(kopsStateStoreInstanceGroupKey)
-	if err != nil {
-		return results, fmt.Errorf("failed to compile instance group matcher: %w", err)
-	}
+// Unwrap exposes the underlying failure.
+func (e *stateStoreObjectError) Unwrap() error {
+	return e.Err
+}
 
-	// Iterate each object.
+// instanceGroupObjectResult holds the outcome of reading one instance group object.
+type instanceGroupObjectResult struct {
+	// Group is the decoded instance group.
+	Group *kops.InstanceGroup
+	// ObjectErr records an unreadable or unparseable object.
+	ObjectErr *stateStoreObjectError
+	// Err records an S3 request stopped by the check deadline or cancellation.
+	Err error
+}
+
+// readInstanceGroupObjects loads instance group YAML from S3 on a bounded worker pool, preserving object order and collecting per-object errors.
+func readInstanceGroupObjects(ctx context.Context, cfg *CheckConfig, awsS3 s3iface.S3API, objects []*s3.Object) ([]*kops.InstanceGroup, []*stateStoreObjectError, error) {
+	// Filter to instance groups of the target cluster.
+	keys := make([]string, 0, len(objects))
 	for _, object := range objects {
 		// Skip objects without a key.
 		if object == nil || object.Key == nil {
 			continue
 		}
-
-		// Filter to instance group paths.
-		if !matcher.MatchString(*object.Key) {
-			log.Debugln("Skipping object with key:", *object.Key)
+		cluster, _, ok := parseInstanceGroupKey(*object.Key)
+		if !ok {
+			log.Debugln("Skipping object that is not an instance group:", *object.Key)
 			continue
 		}
-
-		// Filter to the target cluster.
-		if !strings.Contains(*object.Key, cfg.ClusterName) {
+		if cluster != cfg.ClusterName {
 			log.Debugf("Skipping object due to mismatching cluster names. Object for %s, but looking for %s.", *object.Key, cfg.ClusterName)
 			continue
 		}
+		keys = append(keys, *object.Key)
+	}
 
-		log.Infoln("Information for object with key:", *object.Key)
+	// Bound the worker count.
+	concurrency := cfg.StateStoreReadConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > len(keys) {
+		concurrency = len(keys)
+	}
+	log.Infoln("Reading", len(keys), "S3 objects with", concurrency, "workers.")
 
-		// Request the object from S3.
-		output, err := awsS3.GetObject(&s3.GetObjectInput{
-			Key:    aws.String(*object.Key),
-			Bucket: aws.String(cfg.AWSS3BucketName),
-		})
-		if err != nil {
-			log.Errorf("failed to fetch bucket object with key %s: %s", *object.Key, err.Error())
-			return results, err
-		}
-		if output == nil || output.Body == nil {
-			log.Errorf("object body was empty for key %s", *object.Key)
-			continue
-		}
+	// Read each object into its own slot so the results keep the listing order.
+	slots := make([]instanceGroupObjectResult, len(keys))
+	var wg sync.WaitGroup
+	jobs := make(chan int)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				slots[index] = readInstanceGroupObject(ctx, cfg, awsS3, keys[index])
+			}
+		}()
+	}
+	for index := range keys {
+		jobs <- index
+	}
+	close(jobs)
+	wg.Wait()
 
-		// Read the object body.
-		objectBytes, err := io.ReadAll(output.Body)
-		if err != nil {
-			log.Errorf("failed to read object body: %s", err.Error())
-			continue
+	// Assemble the results in order.
+	results := make([]*kops.InstanceGroup, 0, len(keys))
+	objectErrors := make([]*stateStoreObjectError, 0)
+	requestErrors := make([]string, 0)
+	for _, slot := range slots {
+		switch {
+		case slot.Err != nil:
+			requestErrors = append(requestErrors, slot.Err.Error())
+		case slot.ObjectErr != nil:
+			objectErrors = append(objectErrors, slot.ObjectErr)
+		default:
+			results = append(results, slot.Group)
 		}
+	}
 
-		// Parse YAML into instance group struct.
-		var ig kops.InstanceGroup
-		err = kops.ParseRawYaml(objectBytes, &ig)
-		if err != nil {
-			err = fmt.Errorf("failed to unmarshal yaml data: %w", err)
-			log.Errorln(err)
-			continue
+	// Fail when the run was canceled while reading objects.
+	if len(requestErrors) != 0 {
+		return results, objectErrors, fmt.Errorf("failed to fetch %d state store objects: %s", len(requestErrors), strings.Join(requestErrors, "; "))
+	}
+
+	return results, objectErrors, nil
+}
+
+// readInstanceGroupObject fetches and decodes a single instance group object.
+func readInstanceGroupObject(ctx context.Context, cfg *CheckConfig, awsS3 s3iface.S3API, key string) instanceGroupObjectResult {
+	// Request the object from S3.
+	log.Infoln("Information for object with key:", key)
+	output, err := awsS3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Key:    aws.String(key),
+		Bucket: aws.String(cfg.AWSS3BucketName),
+	})
+	if err != nil {
+		// Only a canceled run is fatal; objects deleted since the listing or refused to the role are reported per object.
+		if ctx.Err() != nil || awsErrorCode(err) == request.CanceledErrorCode {
+			log.Errorf("failed to fetch bucket object with key %s: %s", key, err.Error())
+			return instanceGroupObjectResult{Err: fmt.Errorf("%s: %w", key, err)}
 		}
+		return instanceGroupObjectResult{ObjectErr: newStateStoreObjectError(key, fmt.Errorf("failed to fetch object: %w", err))}
+	}
 
-		// Append the parsed instance group.
-		log.Infoln("Found and unmarshalled data for:", ig.Name)
-		results = append(results, &ig)
+	// Read the object body.
+	objectBytes, objectErr := readStateStoreObject(key, output)
+	if objectErr != nil {
+		return instanceGroupObjectResult{ObjectErr: objectErr}
 	}
 
-	return results, nil
+	// Decode YAML into the instance group struct.
+	ig, err := decodeKopsInstanceGroup(objectBytes)
+	if err != nil {
+		return instanceGroupObjectResult{ObjectErr: newStateStoreObjectError(key, err)}
+	}
+
+	log.Infoln("Found and unmarshalled data for:", ig.Name)
+	return instanceGroupObjectResult{Group: ig}
+}
+
+// readStateStoreObject reads and closes a state store object body, reporting empty or unreadable bodies.
+func readStateStoreObject(key string, output *s3.GetObjectOutput) ([]byte, *stateStoreObjectError) {
+	// Reject missing bodies.
+	if output == nil || output.Body == nil {
+		return nil, newStateStoreObjectError(key, fmt.Errorf("object body was empty"))
+	}
+
+	// Read the object body.
+	objectBytes, err := io.ReadAll(output.Body)
+	output.Body.Close()
+	if err != nil {
+		return nil, newStateStoreObjectError(key, fmt.Errorf("failed to read object body: %w", err))
+	}
+	if len(objectBytes) == 0 {
+		return nil, newStateStoreObjectError(key, fmt.Errorf("object body was empty"))
+	}
+
+	return objectBytes, nil
+}
+
+// decodeKopsInstanceGroup decodes a versioned instance group document into the kops internal type.
+func decodeKopsInstanceGroup(data []byte) (*kops.InstanceGroup, error) {
+	// Decode through the kops codecs so versioned fields such as rootVolumeSize are converted.
+	object, _, err := kopscodecs.Decode(data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal yaml data: %w", err)
+	}
+	ig, ok := object.(*kops.InstanceGroup)
+	if !ok {
+		return nil, fmt.Errorf("expected an InstanceGroup, got %T", object)
+	}
+
+	return ig, nil
+}
+
+// decodeKopsCluster decodes a versioned cluster document into the kops internal type.
+func decodeKopsCluster(data []byte) (*kops.Cluster, error) {
+	// Decode through the kops codecs so versioned fields are converted.
+	object, _, err := kopscodecs.Decode(data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal yaml data: %w", err)
+	}
+	cluster, ok := object.(*kops.Cluster)
+	if !ok {
+		return nil, fmt.Errorf("expected a Cluster, got %T", object)
+	}
+
+	return cluster, nil
+}
+
+// newStateStoreObjectError builds and logs a per-object error.
+func newStateStoreObjectError(key string, err error) *stateStoreObjectError {
+	// Log the failure as it is collected.
+	objectErr := &stateStoreObjectError{Key: key, Err: err}
+	log.Errorln(objectErr.Error())
+
+	return objectErr
 }
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// fakeS3 serves a bucket from memory with a small page size.
+type fakeS3 struct {
+	s3iface.S3API
+	objects  map[string]string
+	pageSize int
+	prefixes []string
+}
+
+// ListObjectsV2PagesWithContext pages through the keys under the requested prefix.
+func (f *fakeS3) ListObjectsV2PagesWithContext(ctx context.Context, input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, opts ...request.Option) error {
+	// Record the requested prefix.
+	prefix := aws.StringValue(input.Prefix)
+	f.prefixes = append(f.prefixes, prefix)
+
+	// Collect the matching keys in order.
+	keys := make([]string, 0)
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	// Emit the keys one page at a time.
+	for start := 0; start == 0 || start < len(keys); start += f.pageSize {
+		end := start + f.pageSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		page := &s3.ListObjectsV2Output{IsTruncated: aws.Bool(end < len(keys))}
+		for _, key := range keys[start:end] {
+			page.Contents = append(page.Contents, &s3.Object{Key: aws.String(key)})
+		}
+		if !fn(page, end >= len(keys)) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// GetObjectWithContext returns the stored object body.
+func (f *fakeS3) GetObjectWithContext(ctx context.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	// Look up the object.
+	body, ok := f.objects[aws.StringValue(input.Key)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "no such key", nil)
+	}
+
+	return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(body))}, nil
+}
+
+// TestListS3ObjectsPaginatesWithPrefix verifies every page under the cluster prefix is listed.
+func TestListS3ObjectsPaginatesWithPrefix(t *testing.T) {
+	// Store more instance groups than fit in one page, plus another cluster.
+	objects := map[string]string{"other.k8s.local/instancegroup/nodes": ""}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		objects["prod.k8s.local/instancegroup/"+name] = ""
+	}
+	client := &fakeS3{objects: objects, pageSize: 2}
+	cfg := &CheckConfig{AWSS3BucketName: "bucket", ClusterName: "prod.k8s.local"}
+
+	// List the objects.
+	results, err := listS3Objects(context.Background(), cfg, client)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != 5 {
+		t.Fatalf("expected five objects, got %d", len(results))
+	}
+	if client.prefixes[0] != "prod.k8s.local/instancegroup/" {
+		t.Fatalf("expected cluster instance group prefix, got %s", client.prefixes[0])
+	}
+}
+
+// buildInstanceGroupYAML renders a minimal instance group document.
+func buildInstanceGroupYAML(name string, image string) string {
+	return "apiVersion: kops.k8s.io/v1alpha2\nkind: InstanceGroup\nmetadata:\n  name: " + name +
+		"\nspec:\n  image: " + image + "\n  machineType: t3.medium\n  role: Node\n"
+}
+
+// TestParseInstanceGroupKey verifies structural parsing of state store keys.
+func TestParseInstanceGroupKey(t *testing.T) {
+	// Parse a valid key.
+	cluster, name, ok := parseInstanceGroupKey("prod.k8s.local/instancegroup/nodes")
+	if !ok || cluster != "prod.k8s.local" || name != "nodes" {
+		t.Fatalf("unexpected parse result: %s %s %t", cluster, name, ok)
+	}
+
+	// Reject keys that are not instance groups.
+	for _, key := range []string{"prod.k8s.local/config", "prod.k8s.local/instancegroup/", "prod.k8s.local/instancegroup/nodes/extra", "/instancegroup/nodes"} {
+		_, _, ok = parseInstanceGroupKey(key)
+		if ok {
+			t.Fatalf("expected %s to be rejected", key)
+		}
+	}
+}
+
+// TestReadInstanceGroupObjectsExactCluster ensures similarly named clusters are excluded.
+func TestReadInstanceGroupObjectsExactCluster(t *testing.T) {
+	// Store instance groups for the cluster and look-alike clusters.
+	client := &fakeS3{objects: map[string]string{
+		"prod.k8s.local/instancegroup/nodes":        buildInstanceGroupYAML("nodes", "kope.io/k8s-1.27"),
+		"preprod.k8s.local/instancegroup/nodes":     buildInstanceGroupYAML("preprod-nodes", "kope.io/k8s-1.27"),
+		"prod.k8s.local-backup/instancegroup/nodes": buildInstanceGroupYAML("backup-nodes", "kope.io/k8s-1.27"),
+	}}
+	objects := make([]*s3.Object, 0)
+	for key := range client.objects {
+		objects = append(objects, &s3.Object{Key: aws.String(key)})
+	}
+	cfg := &CheckConfig{AWSS3BucketName: "bucket", ClusterName: "prod.k8s.local"}
+
+	// Read the objects.
+	groups, objectErrors, err := readInstanceGroupObjects(context.Background(), cfg, client, objects)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(objectErrors) != 0 {
+		t.Fatalf("unexpected object errors: %v", objectErrors)
+	}
+	if len(groups) != 1 || groups[0].Name != "nodes" {
+		t.Fatalf("expected only the nodes group, got %v", groups)
+	}
+}
+
+// TestReadInstanceGroupObjectsCollectsErrors verifies corrupt and empty objects are reported.
+func TestReadInstanceGroupObjectsCollectsErrors(t *testing.T) {
+	// Store one valid, one corrupt, and one empty instance group.
+	client := &fakeS3{objects: map[string]string{
+		"prod.k8s.local/instancegroup/nodes":   buildInstanceGroupYAML("nodes", "kope.io/k8s-1.27"),
+		"prod.k8s.local/instancegroup/corrupt": "spec: [unterminated",
+		"prod.k8s.local/instancegroup/empty":   "",
+	}}
+	objects := []*s3.Object{
+		{Key: aws.String("prod.k8s.local/instancegroup/corrupt")},
+		{Key: aws.String("prod.k8s.local/instancegroup/empty")},
+		{Key: aws.String("prod.k8s.local/instancegroup/nodes")},
+	}
+	cfg := &CheckConfig{AWSS3BucketName: "bucket", ClusterName: "prod.k8s.local"}
+
+	// Read the objects.
+	groups, objectErrors, err := readInstanceGroupObjects(context.Background(), cfg, client, objects)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(groups) != 1 {
+		t.Fatalf("expected one parsed group, got %d", len(groups))
+	}
+	if len(objectErrors) != 2 {
+		t.Fatalf("expected two object errors, got %v", objectErrors)
+	}
+	if objectErrors[0].Key != "prod.k8s.local/instancegroup/corrupt" || objectErrors[1].Key != "prod.k8s.local/instancegroup/empty" {
+		t.Fatalf("unexpected object error keys: %v", objectErrors)
+	}
+}
+
+// TestLoadKopsCluster verifies the cluster spec is read and a missing spec is reported.
+func TestLoadKopsCluster(t *testing.T) {
+	// Store the cluster spec.
+	client := &fakeS3{objects: map[string]string{"prod.k8s.local/config": buildClusterYAML("prod.k8s.local")}}
+	clients := &awsClients{newS3: func(region string) s3iface.S3API { return client }}
+	cfg := &CheckConfig{AWSS3BucketName: "bucket", ClusterName: "prod.k8s.local"}
+
+	// Load the cluster.
+	cluster, objectErr, err := loadKopsCluster(context.Background(), cfg, clients)
+	if err != nil || objectErr != nil {
+		t.Fatalf("unexpected errors: %v %v", err, objectErr)
+	}
+	if cluster.Name != "prod.k8s.local" {
+		t.Fatalf("unexpected cluster name %s", cluster.Name)
+	}
+
+	// A missing spec is an object error.
+	cfg.ClusterName = "missing.k8s.local"
+	cluster, objectErr, err = loadKopsCluster(context.Background(), cfg, clients)
+	if err != nil || cluster != nil || objectErr == nil || objectErr.Key != "missing.k8s.local/config" {
+		t.Fatalf("expected an object error for the missing spec, got %v %v %v", cluster, objectErr, err)
+	}
+}
+
+// TestDecodeKopsInstanceGroupRootVolume verifies versioned root volume fields are converted.
+func TestDecodeKopsInstanceGroupRootVolume(t *testing.T) {
+	// Decode an instance group with flattened root volume fields.
+	ig, err := decodeKopsInstanceGroup([]byte(buildInstanceGroupYAML("nodes", "kope.io/k8s-1.27") + "  rootVolumeSize: 50\n  rootVolumeType: io1\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ig.Spec.RootVolume == nil || *ig.Spec.RootVolume.Size != 50 || *ig.Spec.RootVolume.Type != "io1" {
+		t.Fatalf("expected the root volume to be converted, got %v", ig.Spec.RootVolume)
+	}
+}
+
+// concurrentS3 wraps fakeS3 to delay reads, track concurrency, and fail chosen keys.
+type concurrentS3 struct {
+	*fakeS3
+	lock        sync.Mutex
+	inFlight    int
+	maxInFlight int
+	failKeys    map[string]bool
+}
+
+// GetObjectWithContext records the concurrent reads around the fake read.
+func (c *concurrentS3) GetObjectWithContext(ctx context.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	// Track the reads in flight.
+	c.lock.Lock()
+	c.inFlight++
+	if c.inFlight > c.maxInFlight {
+		c.maxInFlight = c.inFlight
+	}
+	c.lock.Unlock()
+	defer func() {
+		c.lock.Lock()
+		c.inFlight--
+		c.lock.Unlock()
+	}()
+
+	// Hold the read so workers overlap.
+	time.Sleep(time.Millisecond * 5)
+	if c.failKeys[aws.StringValue(input.Key)] {
+		return nil, awserr.New("AccessDenied", "access denied", nil)
+	}
+
+	return c.fakeS3.GetObjectWithContext(ctx, input, opts...)
+}
+
+// TestReadInstanceGroupObjectsConcurrently verifies reads are bounded, ordered, and aggregate request failures.
+func TestReadInstanceGroupObjectsConcurrently(t *testing.T) {
+	// Store twenty instance groups.
+	objects := make([]*s3.Object, 0)
+	stored := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("nodes-%02d", i)
+		key := "prod.k8s.local/instancegroup/" + name
+		stored[key] = buildInstanceGroupYAML(name, "kope.io/k8s-1.27")
+		objects = append(objects, &s3.Object{Key: aws.String(key)})
+	}
+	client := &concurrentS3{fakeS3: &fakeS3{objects: stored}}
+	cfg := &CheckConfig{AWSS3BucketName: "bucket", ClusterName: "prod.k8s.local", StateStoreReadConcurrency: 4}
+
+	// Read the objects.
+	groups, objectErrors, err := readInstanceGroupObjects(context.Background(), cfg, client, objects)
+	if err != nil || len(objectErrors) != 0 {
+		t.Fatalf("unexpected errors: %v %v", err, objectErrors)
+	}
+	if len(groups) != 20 {
+		t.Fatalf("expected twenty groups, got %d", len(groups))
+	}
+	if client.maxInFlight < 2 || client.maxInFlight > 4 {
+		t.Fatalf("expected between two and four concurrent reads, got %d", client.maxInFlight)
+	}
+	for i, group := range groups {
+		if group.Name != fmt.Sprintf("nodes-%02d", i) {
+			t.Fatalf("expected listing order, got %s at %d", group.Name, i)
+		}
+	}
+
+	// Refused reads are object errors in order, and the other groups are kept.
+	client.failKeys = map[string]bool{
+		"prod.k8s.local/instancegroup/nodes-03": true,
+		"prod.k8s.local/instancegroup/nodes-11": true,
+	}
+	groups, objectErrors, err = readInstanceGroupObjects(context.Background(), cfg, client, objects)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(groups) != 18 || len(objectErrors) != 2 || objectErrors[0].Key != "prod.k8s.local/instancegroup/nodes-03" || objectErrors[1].Key != "prod.k8s.local/instancegroup/nodes-11" {
+		t.Fatalf("expected eighteen groups and two ordered object errors, got %d groups and %v", len(groups), objectErrors)
+	}
+
+	// Failures of a canceled run are aggregated in order.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = readInstanceGroupObjects(ctx, cfg, client, objects)
+	if err == nil {
+		t.Fatalf("expected an error for the failed reads")
+	}
+	if !strings.Contains(err.Error(), "failed to fetch 2 state store objects") || strings.Index(err.Error(), "nodes-03") > strings.Index(err.Error(), "nodes-11") {
+		t.Fatalf("expected both failures in order, got %v", err)
+	}
+}
@@ -8,6 +8,8 @@ require (
 	github.com/aws/aws-sdk-go v1.49.13
 	github.com/kuberhealthy/kuberhealthy/v3 v3.0.0-20260111220401-451598410e50
 	github.com/sirupsen/logrus v1.9.3
+	golang.org/x/time v0.9.0
+	k8s.io/apimachinery v0.33.4
 	k8s.io/kops v1.28.2
 )
 
@@ -57,7 +59,6 @@ require (
 	golang.org/x/sys v0.31.0 // indirect
 	golang.org/x/term v0.30.0 // indirect
 	golang.org/x/text v0.23.0 // indirect
-	golang.org/x/time v0.9.0 // indirect
 	google.golang.org/api v0.138.0 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20241209162323-e6fa225c2576 // indirect
@@ -67,7 +68,6 @@ require (
 	gopkg.in/inf.v0 v0.9.1 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 	k8s.io/api v0.33.4 // indirect
-	k8s.io/apimachinery v0.33.4 // indirect
 	k8s.io/client-go v0.33.4 // indirect
 	k8s.io/klog/v2 v2.130.1 // indirect
 	k8s.io/kube-openapi v0.0.0-20250318190949-c8a335a9a2ff // indirect